@@ -0,0 +1,199 @@
+package healthcheck
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    krlog "github.com/unredacted/krouter/internal/log"
+)
+
+// TransitionFunc is called whenever a nexthop's health flips, so callers can
+// reconcile the kernel's ECMP weights and update metrics.
+type TransitionFunc func(t Target, healthy bool)
+
+// Manager runs one monitor goroutine per configured Target and calls
+// onTransition whenever a nexthop's health changes. Reload replaces the set
+// of monitored targets, starting new ones, stopping dropped ones, and
+// leaving unchanged ones running so in-flight failure/success counts aren't
+// reset by an unrelated config edit.
+type Manager struct {
+    onTransition TransitionFunc
+
+    mu       sync.Mutex
+    monitors map[string]*monitor
+}
+
+// NewManager returns a Manager that calls onTransition on every health
+// transition.
+func NewManager(onTransition TransitionFunc) *Manager {
+    return &Manager{
+        onTransition: onTransition,
+        monitors:     make(map[string]*monitor),
+    }
+}
+
+// Reload starts a monitor for every target not already running, stops any
+// running monitor whose target was dropped, restarts any whose Check
+// settings changed, and leaves the rest alone.
+//
+// Stopping a monitor waits for its goroutine to exit, and that goroutine
+// may be mid-probe, calling onTransition -> ... -> IsHealthy, which needs
+// m.mu. So the monitors being stopped are pulled out of the map and
+// unlocked before being waited on, or onTransition's IsHealthy call would
+// deadlock against this very function.
+func (m *Manager) Reload(targets []Target) {
+    desired := make(map[string]Target, len(targets))
+    for _, t := range targets {
+        desired[t.key()] = t
+    }
+
+    m.mu.Lock()
+    var toStop []*monitor
+    for key, mon := range m.monitors {
+        if t, wanted := desired[key]; !wanted || t != mon.target {
+            toStop = append(toStop, mon)
+            delete(m.monitors, key)
+        }
+    }
+    m.mu.Unlock()
+
+    for _, mon := range toStop {
+        mon.stop()
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for key, t := range desired {
+        if _, running := m.monitors[key]; running {
+            continue
+        }
+        mon := newMonitor(t, m.onTransition)
+        m.monitors[key] = mon
+        mon.start()
+    }
+}
+
+// IsHealthy reports the last-known health of the nexthop identified by
+// route/dev/via. Nexthops with no health check configured, or not yet
+// probed, are considered healthy.
+func (m *Manager) IsHealthy(route, dev, via string) bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    mon, ok := m.monitors[(Target{Route: route, Dev: dev, Via: via}).key()]
+    if !ok {
+        return true
+    }
+    return mon.healthy()
+}
+
+// Stop tears down every running monitor. Like Reload, it waits for each
+// monitor outside of m.mu so an in-flight onTransition callback can still
+// call IsHealthy.
+func (m *Manager) Stop() {
+    m.mu.Lock()
+    all := make([]*monitor, 0, len(m.monitors))
+    for key, mon := range m.monitors {
+        all = append(all, mon)
+        delete(m.monitors, key)
+    }
+    m.mu.Unlock()
+
+    for _, mon := range all {
+        mon.stop()
+    }
+}
+
+// monitor tracks one nexthop's consecutive failure/success counts and
+// probes it on Check.Interval until stopped.
+type monitor struct {
+    target Target
+    onTransition TransitionFunc
+
+    cancel context.CancelFunc
+    done   chan struct{}
+
+    mu              sync.Mutex
+    isHealthy       bool
+    consecutiveFail int
+    consecutiveOK   int
+}
+
+func newMonitor(t Target, onTransition TransitionFunc) *monitor {
+    return &monitor{target: t, onTransition: onTransition, isHealthy: true}
+}
+
+func (m *monitor) healthy() bool {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.isHealthy
+}
+
+func (m *monitor) start() {
+    ctx, cancel := context.WithCancel(context.Background())
+    m.cancel = cancel
+    m.done = make(chan struct{})
+
+    go func() {
+        defer close(m.done)
+        ticker := time.NewTicker(m.target.Check.Interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                m.probeOnce(ctx)
+            }
+        }
+    }()
+}
+
+func (m *monitor) stop() {
+    if m.cancel != nil {
+        m.cancel()
+        <-m.done
+    }
+}
+
+func (m *monitor) probeOnce(ctx context.Context) {
+    check := m.target.Check
+    prober, ok := probers[check.Type]
+    if !ok {
+        krlog.Errorf("healthcheck: unknown check type %q for nexthop %s via %s", check.Type, m.target.Dev, m.target.Via)
+        return
+    }
+
+    err := prober(ctx, check.Target, check.Timeout)
+
+    m.mu.Lock()
+    wasHealthy := m.isHealthy
+    if err == nil {
+        m.consecutiveOK++
+        m.consecutiveFail = 0
+        if !wasHealthy && m.consecutiveOK >= check.SuccessThreshold {
+            m.isHealthy = true
+        }
+    } else {
+        m.consecutiveFail++
+        m.consecutiveOK = 0
+        if wasHealthy && m.consecutiveFail >= check.FailureThreshold {
+            m.isHealthy = false
+        }
+    }
+    nowHealthy := m.isHealthy
+    m.mu.Unlock()
+
+    if nowHealthy == wasHealthy {
+        return
+    }
+
+    if nowHealthy {
+        krlog.Infof("healthcheck: nexthop %s via %s (route %s) recovered", m.target.Dev, m.target.Via, m.target.Route)
+    } else {
+        krlog.Warnf("healthcheck: nexthop %s via %s (route %s) unhealthy: %v", m.target.Dev, m.target.Via, m.target.Route, err)
+    }
+    if m.onTransition != nil {
+        m.onTransition(m.target, nowHealthy)
+    }
+}
@@ -0,0 +1,262 @@
+// Package log is krouter's structured, leveled logging subsystem. It replaces
+// the old bool-gated logWriter in main.go with per-level suppression, an
+// optional JSON encoding for log shippers, colorized console output when
+// stdout is a TTY, and accurate caller reporting.
+package log
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "runtime"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Level is a log severity, ordered from most to least verbose.
+type Level int
+
+const (
+    LevelTrace Level = iota
+    LevelDebug
+    LevelInfo
+    LevelWarn
+    LevelError
+    // LevelDisabled suppresses every level, including Error. It exists for
+    // LevelFromLegacyFlags: the old bool-gated logWriter wrote nothing at
+    // all when info/error/debug were all false, and that "fully silenced"
+    // configuration has to keep meaning the same thing after migrating to
+    // Level, rather than falling through to LevelInfo.
+    LevelDisabled
+)
+
+func (l Level) String() string {
+    switch l {
+    case LevelTrace:
+        return "TRACE"
+    case LevelDebug:
+        return "DEBUG"
+    case LevelInfo:
+        return "INFO"
+    case LevelWarn:
+        return "WARN"
+    case LevelError:
+        return "ERROR"
+    case LevelDisabled:
+        return "DISABLED"
+    default:
+        return "UNKNOWN"
+    }
+}
+
+// ParseLevel maps a program_settings.logging.level string onto a Level. It
+// defaults to LevelInfo for an empty or unrecognized value.
+func ParseLevel(s string) Level {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "trace":
+        return LevelTrace
+    case "debug":
+        return LevelDebug
+    case "warn", "warning":
+        return LevelWarn
+    case "error":
+        return LevelError
+    case "info", "":
+        return LevelInfo
+    default:
+        return LevelInfo
+    }
+}
+
+// LevelFromLegacyFlags reproduces the old info/error/debug boolean gate as a
+// Level, for config files that haven't migrated to logging.level yet. If
+// all of info, warn, errEnabled and debug are false, the old logWriter
+// wrote nothing at all, so that combination maps to LevelDisabled rather
+// than falling through to LevelInfo.
+func LevelFromLegacyFlags(info, warn, errEnabled, debug bool) Level {
+    switch {
+    case debug:
+        return LevelDebug
+    case errEnabled && !info:
+        return LevelError
+    case warn && !info:
+        return LevelWarn
+    case !info && !warn && !errEnabled:
+        return LevelDisabled
+    default:
+        return LevelInfo
+    }
+}
+
+// Config controls how a Logger writes and filters records.
+type Config struct {
+    Level   Level
+    JSON    bool
+    Color   bool // colorize console output; ignored when JSON is true
+    Console io.Writer
+    File    io.Writer // typically a *rotate.File; nil disables file output
+}
+
+// Logger is a leveled, optionally JSON-encoded, optionally colorized logger.
+// The zero value is not usable; construct one with New.
+type Logger struct {
+    mu      sync.Mutex
+    level   Level
+    json    bool
+    color   bool
+    console io.Writer
+    file    io.Writer
+}
+
+// New builds a Logger from cfg.
+func New(cfg Config) *Logger {
+    return &Logger{
+        level:   cfg.Level,
+        json:    cfg.JSON,
+        color:   cfg.Color,
+        console: cfg.Console,
+        file:    cfg.File,
+    }
+}
+
+// IsTTY reports whether f is attached to a terminal, for deciding whether to
+// colorize console output.
+func IsTTY(f *os.File) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice != 0
+}
+
+var (
+    defaultMu     sync.RWMutex
+    defaultLogger = New(Config{Level: LevelInfo, Console: os.Stdout})
+)
+
+// SetDefault replaces the package-level Logger used by Info, Debug, etc.
+func SetDefault(l *Logger) {
+    defaultMu.Lock()
+    defer defaultMu.Unlock()
+    defaultLogger = l
+}
+
+// Default returns the package-level Logger.
+func Default() *Logger {
+    defaultMu.RLock()
+    defer defaultMu.RUnlock()
+    return defaultLogger
+}
+
+type record struct {
+    Time    string `json:"time"`
+    Level   string `json:"level"`
+    Caller  string `json:"caller"`
+    Message string `json:"message"`
+}
+
+var levelColor = map[Level]string{
+    LevelTrace: "\x1b[90m", // bright black
+    LevelDebug: "\x1b[36m", // cyan
+    LevelInfo:  "\x1b[32m", // green
+    LevelWarn:  "\x1b[33m", // yellow
+    LevelError: "\x1b[31m", // red
+}
+
+const colorReset = "\x1b[0m"
+
+func caller(skip int) string {
+    _, file, line, ok := runtime.Caller(skip)
+    if !ok {
+        return "???"
+    }
+    if idx := strings.LastIndexByte(file, '/'); idx >= 0 {
+        file = file[idx+1:]
+    }
+    return fmt.Sprintf("%s:%d", file, line)
+}
+
+func (l *Logger) log(skip int, level Level, msg string) {
+    if level < l.level {
+        return
+    }
+    now := time.Now().Format("2006/01/02 15:04:05")
+    site := caller(skip + 1)
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if l.json {
+        rec := record{Time: now, Level: level.String(), Caller: site, Message: msg}
+        line, err := json.Marshal(rec)
+        if err != nil {
+            return
+        }
+        line = append(line, '\n')
+        if l.console != nil {
+            l.console.Write(line)
+        }
+        if l.file != nil {
+            l.file.Write(line)
+        }
+        return
+    }
+
+    plain := fmt.Sprintf("%s [%s] %s: %s\n", now, level.String(), site, msg)
+    if l.console != nil {
+        if l.color {
+            l.console.Write([]byte(levelColor[level] + strings.TrimSuffix(plain, "\n") + colorReset + "\n"))
+        } else {
+            l.console.Write([]byte(plain))
+        }
+    }
+    if l.file != nil {
+        l.file.Write([]byte(plain))
+    }
+}
+
+func (l *Logger) Trace(args ...interface{})                 { l.log(2, LevelTrace, fmt.Sprint(args...)) }
+func (l *Logger) Tracef(format string, args ...interface{}) { l.log(2, LevelTrace, fmt.Sprintf(format, args...)) }
+func (l *Logger) Debug(args ...interface{})                 { l.log(2, LevelDebug, fmt.Sprint(args...)) }
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(2, LevelDebug, fmt.Sprintf(format, args...)) }
+func (l *Logger) Info(args ...interface{})                  { l.log(2, LevelInfo, fmt.Sprint(args...)) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(2, LevelInfo, fmt.Sprintf(format, args...)) }
+func (l *Logger) Warn(args ...interface{})                  { l.log(2, LevelWarn, fmt.Sprint(args...)) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(2, LevelWarn, fmt.Sprintf(format, args...)) }
+func (l *Logger) Error(args ...interface{})                 { l.log(2, LevelError, fmt.Sprint(args...)) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(2, LevelError, fmt.Sprintf(format, args...)) }
+
+func (l *Logger) Fatal(args ...interface{}) {
+    l.log(2, LevelError, fmt.Sprint(args...))
+    os.Exit(1)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+    l.log(2, LevelError, fmt.Sprintf(format, args...))
+    os.Exit(1)
+}
+
+// Package-level helpers delegate to the default Logger, mirroring the
+// log.Print* ergonomics the rest of the codebase already expects.
+func Trace(args ...interface{})                 { Default().log(2, LevelTrace, fmt.Sprint(args...)) }
+func Tracef(format string, args ...interface{}) { Default().log(2, LevelTrace, fmt.Sprintf(format, args...)) }
+func Debug(args ...interface{})                 { Default().log(2, LevelDebug, fmt.Sprint(args...)) }
+func Debugf(format string, args ...interface{}) { Default().log(2, LevelDebug, fmt.Sprintf(format, args...)) }
+func Info(args ...interface{})                  { Default().log(2, LevelInfo, fmt.Sprint(args...)) }
+func Infof(format string, args ...interface{})  { Default().log(2, LevelInfo, fmt.Sprintf(format, args...)) }
+func Warn(args ...interface{})                  { Default().log(2, LevelWarn, fmt.Sprint(args...)) }
+func Warnf(format string, args ...interface{})  { Default().log(2, LevelWarn, fmt.Sprintf(format, args...)) }
+func Error(args ...interface{})                 { Default().log(2, LevelError, fmt.Sprint(args...)) }
+func Errorf(format string, args ...interface{}) { Default().log(2, LevelError, fmt.Sprintf(format, args...)) }
+
+func Fatal(args ...interface{}) {
+    Default().log(2, LevelError, fmt.Sprint(args...))
+    os.Exit(1)
+}
+
+func Fatalf(format string, args ...interface{}) {
+    Default().log(2, LevelError, fmt.Sprintf(format, args...))
+    os.Exit(1)
+}
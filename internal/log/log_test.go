@@ -0,0 +1,24 @@
+package log
+
+import "testing"
+
+func TestLevelFromLegacyFlags(t *testing.T) {
+    cases := []struct {
+        name                       string
+        info, warn, err, debug bool
+        want                       Level
+    }{
+        {"debug wins over everything", true, true, true, true, LevelDebug},
+        {"error only", false, false, true, false, LevelError},
+        {"warn only", false, true, false, false, LevelWarn},
+        {"info only", true, false, false, false, LevelInfo},
+        {"all false is fully silenced, not info", false, false, false, false, LevelDisabled},
+    }
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            if got := LevelFromLegacyFlags(c.info, c.warn, c.err, c.debug); got != c.want {
+                t.Errorf("LevelFromLegacyFlags(%v, %v, %v, %v) = %s, want %s", c.info, c.warn, c.err, c.debug, got, c.want)
+            }
+        })
+    }
+}
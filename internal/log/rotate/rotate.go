@@ -0,0 +1,206 @@
+// Package rotate implements size-based log file rotation with gzip
+// compression of rolled files and age- and count-based cleanup of rotated
+// files, so a long-running krouter process doesn't grow its log file
+// forever under os.O_APPEND. The active file is not rotated by age.
+package rotate
+
+import (
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Options configures a File's rotation behavior.
+type Options struct {
+    // MaxSizeMB rotates the active file once it exceeds this size. Zero
+    // disables size-based rotation.
+    MaxSizeMB int
+    // MaxAgeDays deletes rotated (and gzipped) files older than this many
+    // days. Zero disables age-based cleanup.
+    MaxAgeDays int
+    // MaxBackups caps the number of rotated files retained, oldest deleted
+    // first. Zero means unlimited.
+    MaxBackups int
+    // Compress gzips a file as soon as it is rotated out.
+    Compress bool
+}
+
+// File is an io.WriteCloser that transparently rotates the underlying log
+// file according to Options.
+type File struct {
+    path string
+    opts Options
+
+    mu       sync.Mutex
+    f        *os.File
+    size     int64
+    openedAt time.Time
+}
+
+// Open opens (creating if necessary) the log file at path and prepares it
+// for rotation-aware writes.
+func Open(path string, opts Options) (*File, error) {
+    f := &File{path: path, opts: opts}
+    if err := f.openExisting(); err != nil {
+        return nil, err
+    }
+    f.cleanup()
+    return f, nil
+}
+
+func (f *File) openExisting() error {
+    file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+    if err != nil {
+        return fmt.Errorf("opening log file %q: %w", f.path, err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("stat log file %q: %w", f.path, err)
+    }
+    f.f = file
+    f.size = info.Size()
+    f.openedAt = info.ModTime()
+    return nil
+}
+
+// Write implements io.Writer, rotating first if the write would exceed
+// MaxSizeMB.
+func (f *File) Write(p []byte) (int, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if f.opts.MaxSizeMB > 0 && f.size+int64(len(p)) > int64(f.opts.MaxSizeMB)*1024*1024 {
+        if err := f.rotateLocked(); err != nil {
+            return 0, err
+        }
+    }
+
+    n, err := f.f.Write(p)
+    f.size += int64(n)
+    return n, err
+}
+
+// Close closes the underlying file.
+func (f *File) Close() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.f.Close()
+}
+
+// Rotate forces rotation of the active file regardless of its size.
+func (f *File) Rotate() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.rotateLocked()
+}
+
+func (f *File) rotateLocked() error {
+    if err := f.f.Close(); err != nil {
+        return fmt.Errorf("closing log file %q before rotation: %w", f.path, err)
+    }
+
+    rotatedPath := fmt.Sprintf("%s.%s", f.path, time.Now().Format("20060102-150405"))
+    if err := os.Rename(f.path, rotatedPath); err != nil {
+        return fmt.Errorf("rotating log file %q: %w", f.path, err)
+    }
+
+    if f.opts.Compress {
+        if err := gzipFile(rotatedPath); err != nil {
+            return fmt.Errorf("compressing rotated log file %q: %w", rotatedPath, err)
+        }
+    }
+
+    if err := f.openExisting(); err != nil {
+        return err
+    }
+
+    f.cleanup()
+    return nil
+}
+
+func gzipFile(path string) error {
+    src, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+
+    dst, err := os.Create(path + ".gz")
+    if err != nil {
+        return err
+    }
+
+    gw := gzip.NewWriter(dst)
+    if _, err := io.Copy(gw, src); err != nil {
+        gw.Close()
+        dst.Close()
+        os.Remove(path + ".gz")
+        return err
+    }
+    if err := gw.Close(); err != nil {
+        dst.Close()
+        return err
+    }
+    if err := dst.Close(); err != nil {
+        return err
+    }
+    return os.Remove(path)
+}
+
+// cleanup removes rotated files older than MaxAgeDays and, beyond that,
+// keeps only the MaxBackups most recent ones.
+func (f *File) cleanup() {
+    if f.opts.MaxAgeDays <= 0 && f.opts.MaxBackups <= 0 {
+        return
+    }
+
+    dir := filepath.Dir(f.path)
+    base := filepath.Base(f.path)
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return
+    }
+
+    var rotated []string
+    for _, e := range entries {
+        if e.IsDir() {
+            continue
+        }
+        name := e.Name()
+        if name == base {
+            continue
+        }
+        if strings.HasPrefix(name, base+".") {
+            rotated = append(rotated, filepath.Join(dir, name))
+        }
+    }
+
+    sort.Strings(rotated) // timestamp suffix sorts chronologically
+
+    if f.opts.MaxAgeDays > 0 {
+        cutoff := time.Now().AddDate(0, 0, -f.opts.MaxAgeDays)
+        kept := rotated[:0]
+        for _, path := range rotated {
+            info, err := os.Stat(path)
+            if err != nil || info.ModTime().Before(cutoff) {
+                os.Remove(path)
+                continue
+            }
+            kept = append(kept, path)
+        }
+        rotated = kept
+    }
+
+    if f.opts.MaxBackups > 0 && len(rotated) > f.opts.MaxBackups {
+        for _, path := range rotated[:len(rotated)-f.opts.MaxBackups] {
+            os.Remove(path)
+        }
+    }
+}
@@ -0,0 +1,108 @@
+// Package metrics is a minimal Prometheus text-exposition-format registry,
+// just big enough for krouter's nexthop health counters. It avoids pulling
+// in client_golang for three metrics.
+package metrics
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Registry holds a fixed set of gauges and counters, each optionally keyed
+// by a label set, and serves them in Prometheus text format.
+type Registry struct {
+    mu       sync.Mutex
+    gauges   map[string]float64
+    counters map[string]float64
+    help     map[string]string // metric name -> HELP text, keyed by base name
+    typ      map[string]string // metric name -> TYPE, keyed by base name
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+    return &Registry{
+        gauges:   make(map[string]float64),
+        counters: make(map[string]float64),
+        help:     make(map[string]string),
+        typ:      make(map[string]string),
+    }
+}
+
+func labelString(labels map[string]string) string {
+    if len(labels) == 0 {
+        return ""
+    }
+    keys := make([]string, 0, len(labels))
+    for k := range labels {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    parts := make([]string, 0, len(keys))
+    for _, k := range keys {
+        parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+    }
+    return "{" + strings.Join(parts, ",") + "}"
+}
+
+// SetGauge sets name{labels} to value, registering help text for name the
+// first time it's seen.
+func (r *Registry) SetGauge(name, help string, labels map[string]string, value float64) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.help[name] = help
+    r.typ[name] = "gauge"
+    r.gauges[name+labelString(labels)] = value
+}
+
+// IncCounter adds delta to name{labels}, registering help text for name the
+// first time it's seen.
+func (r *Registry) IncCounter(name, help string, labels map[string]string, delta float64) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.help[name] = help
+    r.typ[name] = "counter"
+    r.counters[name+labelString(labels)] += delta
+}
+
+// Handler returns an http.Handler serving the registry in Prometheus text
+// exposition format, for mounting at /metrics.
+func (r *Registry) Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+        names := make(map[string]bool, len(r.help))
+        for n := range r.help {
+            names[n] = true
+        }
+        sorted := make([]string, 0, len(names))
+        for n := range names {
+            sorted = append(sorted, n)
+        }
+        sort.Strings(sorted)
+
+        for _, name := range sorted {
+            fmt.Fprintf(w, "# HELP %s %s\n", name, r.help[name])
+            fmt.Fprintf(w, "# TYPE %s %s\n", name, r.typ[name])
+            series := r.gauges
+            if r.typ[name] == "counter" {
+                series = r.counters
+            }
+            keys := make([]string, 0)
+            for k := range series {
+                if k == name || strings.HasPrefix(k, name+"{") {
+                    keys = append(keys, k)
+                }
+            }
+            sort.Strings(keys)
+            for _, k := range keys {
+                fmt.Fprintf(w, "%s %v\n", k, series[k])
+            }
+        }
+    })
+}
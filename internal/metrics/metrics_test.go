@@ -0,0 +1,26 @@
+package metrics
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestRegistry_HandlerRendersGaugesAndCounters(t *testing.T) {
+    r := NewRegistry()
+    r.SetGauge("krouter_nexthop_up", "1 if healthy", map[string]string{"tunnel": "10.0.0.0/24", "nexthop": "eth0@10.0.0.1"}, 1)
+    r.IncCounter("krouter_nexthop_transitions_total", "total transitions", map[string]string{"tunnel": "10.0.0.0/24", "nexthop": "eth0@10.0.0.1"}, 1)
+    r.IncCounter("krouter_nexthop_transitions_total", "total transitions", map[string]string{"tunnel": "10.0.0.0/24", "nexthop": "eth0@10.0.0.1"}, 1)
+
+    req := httptest.NewRequest("GET", "/metrics", nil)
+    rec := httptest.NewRecorder()
+    r.Handler().ServeHTTP(rec, req)
+
+    body := rec.Body.String()
+    if !strings.Contains(body, `krouter_nexthop_up{nexthop="eth0@10.0.0.1",tunnel="10.0.0.0/24"} 1`) {
+        t.Fatalf("missing nexthop_up sample, got:\n%s", body)
+    }
+    if !strings.Contains(body, `krouter_nexthop_transitions_total{nexthop="eth0@10.0.0.1",tunnel="10.0.0.0/24"} 2`) {
+        t.Fatalf("expected transitions counter to accumulate to 2, got:\n%s", body)
+    }
+}
@@ -0,0 +1,144 @@
+package netlinkbackend
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+
+    "github.com/unredacted/krouter/internal/reconcile"
+)
+
+// IPRoute2 is a reconcile.Backend that shells out to the `ip` binary. It's
+// the historical implementation, kept as a fallback for environments
+// without CAP_NET_ADMIN via netlink (e.g. some containers and gVisor
+// sandboxes); Netlink is preferred where available since it avoids a
+// fork-per-operation and surfaces typed kernel errors.
+type IPRoute2 struct{}
+
+// NewIPRoute2 returns an IPRoute2 backend.
+func NewIPRoute2() IPRoute2 { return IPRoute2{} }
+
+func (IPRoute2) run(args ...string) (string, error) {
+    cmd := exec.Command("ip", args...)
+    var out bytes.Buffer
+    cmd.Stdout = &out
+    cmd.Stderr = os.Stderr
+    err := cmd.Run()
+    return out.String(), err
+}
+
+func (b IPRoute2) EnsureGRETunnel(spec reconcile.TunnelSpec) error {
+    if _, err := b.run("tunnel", "add", spec.Name, "mode", "gre", "local", spec.LocalIP, "remote", spec.RemoteIP); err != nil {
+        return fmt.Errorf("adding tunnel %s: %w", spec.Name, err)
+    }
+    if _, err := b.run("addr", "add", spec.TunnelIP+"/"+spec.SubnetMask, "dev", spec.Name); err != nil {
+        return fmt.Errorf("adding address to tunnel %s: %w", spec.Name, err)
+    }
+    if _, err := b.run("link", "set", spec.Name, "up"); err != nil {
+        return fmt.Errorf("bringing up tunnel %s: %w", spec.Name, err)
+    }
+    return nil
+}
+
+func (b IPRoute2) DeleteTunnel(name string) error {
+    if _, err := b.run("tunnel", "del", name); err != nil {
+        return fmt.Errorf("deleting tunnel %s: %w", name, err)
+    }
+    return nil
+}
+
+func (b IPRoute2) ListTunnels() ([]reconcile.TunnelState, error) {
+    out, err := b.run("-json", "tunnel", "show")
+    if err != nil {
+        return nil, fmt.Errorf("listing tunnels: %w", err)
+    }
+    var raw []struct {
+        Ifname string `json:"ifname"`
+        Local  string `json:"local"`
+        Remote string `json:"remote"`
+    }
+    if err := json.Unmarshal([]byte(out), &raw); err != nil {
+        return nil, fmt.Errorf("parsing ip -json tunnel show: %w", err)
+    }
+    states := make([]reconcile.TunnelState, 0, len(raw))
+    for _, t := range raw {
+        states = append(states, reconcile.TunnelState{Name: t.Ifname, LocalIP: t.Local, RemoteIP: t.Remote})
+    }
+    return states, nil
+}
+
+func (b IPRoute2) EnsureRoute(spec reconcile.RouteSpec) error {
+    table := tableOrMain(spec.Table)
+    if _, err := b.run("route", "replace", spec.Destination, "via", spec.Gateway, "table", table); err != nil {
+        return fmt.Errorf("adding route %s via %s: %w", spec.Destination, spec.Gateway, err)
+    }
+    return nil
+}
+
+func (b IPRoute2) DeleteRoute(spec reconcile.RouteSpec) error {
+    table := tableOrMain(spec.Table)
+    if _, err := b.run("route", "del", spec.Destination, "table", table); err != nil {
+        return fmt.Errorf("deleting route %s: %w", spec.Destination, err)
+    }
+    return nil
+}
+
+func (b IPRoute2) ListRoutes(table string) ([]reconcile.RouteState, error) {
+    out, err := b.run("-json", "route", "show", "table", tableOrMain(table))
+    if err != nil {
+        return nil, fmt.Errorf("listing routes in table %s: %w", table, err)
+    }
+    var raw []struct {
+        Dst      string `json:"dst"`
+        Gateway  string `json:"gateway"`
+        Nexthops []struct {
+            Dev    string `json:"dev"`
+            Gw     string `json:"gateway"`
+            Weight int    `json:"weight"`
+        } `json:"nexthops"`
+    }
+    if err := json.Unmarshal([]byte(out), &raw); err != nil {
+        return nil, fmt.Errorf("parsing ip -json route show table %s: %w", table, err)
+    }
+
+    states := make([]reconcile.RouteState, 0, len(raw))
+    for _, rt := range raw {
+        state := reconcile.RouteState{Destination: rt.Dst, Gateway: rt.Gateway}
+        for _, nh := range rt.Nexthops {
+            weight := nh.Weight
+            if weight == 0 {
+                weight = 1
+            }
+            state.Nexthops = append(state.Nexthops, reconcile.NexthopSpec{Dev: nh.Dev, Via: nh.Gw, Weight: weight})
+        }
+        states = append(states, state)
+    }
+    return states, nil
+}
+
+func (b IPRoute2) EnsureECMPRoute(spec reconcile.ECMPSpec) error {
+    args := []string{"route", "replace", spec.Route, "proto", "static", "scope", "global", "table", tableOrMain(spec.Table)}
+    for _, nh := range spec.Nexthops {
+        args = append(args, "nexthop", "dev", nh.Dev, "via", nh.Via, "weight", fmt.Sprintf("%d", nh.Weight))
+    }
+    if _, err := b.run(args...); err != nil {
+        return fmt.Errorf("adding ecmp route %s: %w", spec.Route, err)
+    }
+    return nil
+}
+
+func (b IPRoute2) DeleteECMPRoute(spec reconcile.ECMPSpec) error {
+    if _, err := b.run("route", "del", spec.Route, "table", tableOrMain(spec.Table)); err != nil {
+        return fmt.Errorf("deleting ecmp route %s: %w", spec.Route, err)
+    }
+    return nil
+}
+
+func tableOrMain(table string) string {
+    if table == "" {
+        return "main"
+    }
+    return table
+}
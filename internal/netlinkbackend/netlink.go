@@ -0,0 +1,231 @@
+// Package netlinkbackend implements reconcile.Backend two ways: Netlink
+// talks to the kernel directly via github.com/vishvananda/netlink, and
+// IPRoute2 shells out to the `ip` binary for environments without
+// CAP_NET_ADMIN via netlink. Select picks between them from the
+// program_settings.backend config knob.
+package netlinkbackend
+
+import (
+    "fmt"
+    "net"
+    "strconv"
+
+    "github.com/vishvananda/netlink"
+    "golang.org/x/sys/unix"
+
+    "github.com/unredacted/krouter/internal/reconcile"
+)
+
+// Select returns the Backend named by program_settings.backend: "netlink"
+// or "iproute2". An empty name keeps the historical `ip`-shelling behavior
+// so existing config.yml files don't change backend on upgrade.
+func Select(name string) (reconcile.Backend, error) {
+    switch name {
+    case "", "iproute2":
+        return NewIPRoute2(), nil
+    case "netlink":
+        return NewNetlink(), nil
+    default:
+        return nil, fmt.Errorf("unknown program_settings.backend %q, want \"netlink\" or \"iproute2\"", name)
+    }
+}
+
+// Netlink is a reconcile.Backend built on github.com/vishvananda/netlink.
+// It avoids a fork-per-operation shell-out to `ip`, applies each route
+// change as a single netlink request, and surfaces typed
+// kernel errors (unix.EEXIST, unix.ENETUNREACH) instead of opaque
+// "exit status 2" text.
+type Netlink struct{}
+
+// NewNetlink returns a Netlink backend.
+func NewNetlink() Netlink { return Netlink{} }
+
+func (Netlink) EnsureGRETunnel(spec reconcile.TunnelSpec) error {
+    local := net.ParseIP(spec.LocalIP)
+    if local == nil {
+        return fmt.Errorf("invalid local_ip %q for tunnel %s", spec.LocalIP, spec.Name)
+    }
+    remote := net.ParseIP(spec.RemoteIP)
+    if remote == nil {
+        return fmt.Errorf("invalid remote_ip %q for tunnel %s", spec.RemoteIP, spec.Name)
+    }
+
+    link := &netlink.Gretun{
+        LinkAttrs: netlink.LinkAttrs{Name: spec.Name},
+        Local:     local,
+        Remote:    remote,
+    }
+    if err := netlink.LinkAdd(link); err != nil && err != unix.EEXIST {
+        return fmt.Errorf("adding gre tunnel %s: %w", spec.Name, err)
+    }
+
+    addr, err := netlink.ParseAddr(spec.TunnelIP + "/" + spec.SubnetMask)
+    if err != nil {
+        return fmt.Errorf("parsing tunnel address %s/%s: %w", spec.TunnelIP, spec.SubnetMask, err)
+    }
+    if err := netlink.AddrAdd(link, addr); err != nil && err != unix.EEXIST {
+        return fmt.Errorf("adding address to tunnel %s: %w", spec.Name, err)
+    }
+
+    if err := netlink.LinkSetUp(link); err != nil {
+        return fmt.Errorf("bringing up tunnel %s: %w", spec.Name, err)
+    }
+    return nil
+}
+
+func (Netlink) DeleteTunnel(name string) error {
+    link, err := netlink.LinkByName(name)
+    if err != nil {
+        if _, ok := err.(netlink.LinkNotFoundError); ok {
+            return nil
+        }
+        return fmt.Errorf("looking up tunnel %s: %w", name, err)
+    }
+    if err := netlink.LinkDel(link); err != nil {
+        return fmt.Errorf("deleting tunnel %s: %w", name, err)
+    }
+    return nil
+}
+
+func (Netlink) ListTunnels() ([]reconcile.TunnelState, error) {
+    links, err := netlink.LinkList()
+    if err != nil {
+        return nil, fmt.Errorf("listing links: %w", err)
+    }
+    var states []reconcile.TunnelState
+    for _, l := range links {
+        gre, ok := l.(*netlink.Gretun)
+        if !ok {
+            continue
+        }
+        states = append(states, reconcile.TunnelState{
+            Name:     gre.Attrs().Name,
+            LocalIP:  gre.Local.String(),
+            RemoteIP: gre.Remote.String(),
+        })
+    }
+    return states, nil
+}
+
+func parseTable(table string) (int, error) {
+    if table == "" || table == "main" {
+        return unix.RT_TABLE_MAIN, nil
+    }
+    n, err := strconv.Atoi(table)
+    if err != nil {
+        return 0, fmt.Errorf("invalid routing table %q: %w", table, err)
+    }
+    return n, nil
+}
+
+func (Netlink) EnsureRoute(spec reconcile.RouteSpec) error {
+    table, err := parseTable(spec.Table)
+    if err != nil {
+        return err
+    }
+    _, dst, err := net.ParseCIDR(spec.Destination)
+    if err != nil {
+        return fmt.Errorf("parsing destination %s: %w", spec.Destination, err)
+    }
+    gw := net.ParseIP(spec.Gateway)
+    if gw == nil {
+        return fmt.Errorf("invalid gateway %q", spec.Gateway)
+    }
+
+    route := &netlink.Route{Dst: dst, Gw: gw, Table: table}
+    if err := netlink.RouteReplace(route); err != nil {
+        return fmt.Errorf("adding route %s via %s: %w", spec.Destination, spec.Gateway, err)
+    }
+    return nil
+}
+
+func (Netlink) DeleteRoute(spec reconcile.RouteSpec) error {
+    table, err := parseTable(spec.Table)
+    if err != nil {
+        return err
+    }
+    _, dst, err := net.ParseCIDR(spec.Destination)
+    if err != nil {
+        return fmt.Errorf("parsing destination %s: %w", spec.Destination, err)
+    }
+
+    if err := netlink.RouteDel(&netlink.Route{Dst: dst, Table: table}); err != nil && err != unix.ESRCH {
+        return fmt.Errorf("deleting route %s: %w", spec.Destination, err)
+    }
+    return nil
+}
+
+func (Netlink) ListRoutes(table string) ([]reconcile.RouteState, error) {
+    t, err := parseTable(table)
+    if err != nil {
+        return nil, err
+    }
+    routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: t}, netlink.RT_FILTER_TABLE)
+    if err != nil {
+        return nil, fmt.Errorf("listing routes in table %s: %w", table, err)
+    }
+
+    states := make([]reconcile.RouteState, 0, len(routes))
+    for _, rt := range routes {
+        if rt.Dst == nil {
+            continue
+        }
+        state := reconcile.RouteState{Destination: rt.Dst.String()}
+        if rt.Gw != nil {
+            state.Gateway = rt.Gw.String()
+        }
+        for _, nh := range rt.MultiPath {
+            dev := ""
+            if link, err := netlink.LinkByIndex(nh.LinkIndex); err == nil {
+                dev = link.Attrs().Name
+            }
+            state.Nexthops = append(state.Nexthops, reconcile.NexthopSpec{Dev: dev, Via: nh.Gw.String(), Weight: nh.Hops + 1})
+        }
+        states = append(states, state)
+    }
+    return states, nil
+}
+
+func (n Netlink) EnsureECMPRoute(spec reconcile.ECMPSpec) error {
+    table, err := parseTable(spec.Table)
+    if err != nil {
+        return err
+    }
+    _, dst, err := net.ParseCIDR(spec.Route)
+    if err != nil {
+        return fmt.Errorf("parsing ecmp route %s: %w", spec.Route, err)
+    }
+
+    nexthops := make([]*netlink.NexthopInfo, 0, len(spec.Nexthops))
+    for _, nh := range spec.Nexthops {
+        link, err := netlink.LinkByName(nh.Dev)
+        if err != nil {
+            return fmt.Errorf("resolving nexthop device %s: %w", nh.Dev, err)
+        }
+        gw := net.ParseIP(nh.Via)
+        if gw == nil {
+            return fmt.Errorf("invalid nexthop gateway %q", nh.Via)
+        }
+        weight := nh.Weight - 1 // netlink hop count is zero-based
+        if weight < 0 {
+            weight = 0
+        }
+        nexthops = append(nexthops, &netlink.NexthopInfo{LinkIndex: link.Attrs().Index, Gw: gw, Hops: weight})
+    }
+
+    route := &netlink.Route{
+        Dst:       dst,
+        Table:     table,
+        MultiPath: nexthops,
+        Protocol:  unix.RTPROT_STATIC,
+        Scope:     netlink.SCOPE_UNIVERSE,
+    }
+    if err := netlink.RouteReplace(route); err != nil {
+        return fmt.Errorf("adding ecmp route %s: %w", spec.Route, err)
+    }
+    return nil
+}
+
+func (n Netlink) DeleteECMPRoute(spec reconcile.ECMPSpec) error {
+    return n.DeleteRoute(reconcile.RouteSpec{Destination: spec.Route, Table: spec.Table})
+}
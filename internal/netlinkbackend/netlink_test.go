@@ -0,0 +1,129 @@
+package netlinkbackend
+
+import (
+    "os"
+    "runtime"
+    "strings"
+    "testing"
+
+    "github.com/vishvananda/netlink"
+    "github.com/vishvananda/netns"
+
+    "github.com/unredacted/krouter/internal/reconcile"
+)
+
+// skipIfUnsupported skips the test when err indicates the sandbox's kernel
+// lacks a capability a netns test depends on (e.g. the gre module, or
+// policy routing), rather than failing a build that can't reasonably
+// exercise real kernel behavior.
+func skipIfUnsupported(t *testing.T, err error) {
+    t.Helper()
+    if err == nil {
+        return
+    }
+    msg := err.Error()
+    if strings.Contains(msg, "operation not supported") || strings.Contains(msg, "no such device") {
+        t.Skipf("kernel capability unavailable in this environment: %v", err)
+    }
+    t.Fatalf("%v", err)
+}
+
+// withNetNS runs fn inside a freshly created, isolated network namespace so
+// the test can create real GRE tunnels and routes without touching the
+// host's routing table. It skips when not root, since CAP_NET_ADMIN is
+// required to create a namespace.
+func withNetNS(t *testing.T, fn func()) {
+    t.Helper()
+    if os.Getuid() != 0 {
+        t.Skip("requires root (CAP_NET_ADMIN) to create a network namespace")
+    }
+
+    runtime.LockOSThread()
+    defer runtime.UnlockOSThread()
+
+    origNS, err := netns.Get()
+    if err != nil {
+        t.Fatalf("getting current netns: %v", err)
+    }
+    defer origNS.Close()
+    defer netns.Set(origNS)
+
+    newNS, err := netns.New()
+    if err != nil {
+        t.Fatalf("creating netns: %v", err)
+    }
+    defer newNS.Close()
+
+    fn()
+}
+
+func TestNetlink_EnsureAndDeleteGRETunnel(t *testing.T) {
+    withNetNS(t, func() {
+        b := NewNetlink()
+        spec := reconcile.TunnelSpec{
+            Name:       "grenstest",
+            LocalIP:    "10.0.0.1",
+            RemoteIP:   "10.0.0.2",
+            TunnelIP:   "192.168.100.1",
+            SubnetMask: "30",
+        }
+
+        if err := b.EnsureGRETunnel(spec); err != nil {
+            skipIfUnsupported(t, err)
+            return
+        }
+
+        tunnels, err := b.ListTunnels()
+        if err != nil {
+            t.Fatalf("ListTunnels: %v", err)
+        }
+        if len(tunnels) != 1 || tunnels[0].Name != spec.Name || tunnels[0].LocalIP != spec.LocalIP || tunnels[0].RemoteIP != spec.RemoteIP {
+            t.Fatalf("ListTunnels = %v, want [{%s %s %s}]", tunnels, spec.Name, spec.LocalIP, spec.RemoteIP)
+        }
+
+        if err := b.DeleteTunnel(spec.Name); err != nil {
+            t.Fatalf("DeleteTunnel: %v", err)
+        }
+
+        tunnels, err = b.ListTunnels()
+        if err != nil {
+            t.Fatalf("ListTunnels after delete: %v", err)
+        }
+        if len(tunnels) != 0 {
+            t.Fatalf("ListTunnels after delete = %v, want none", tunnels)
+        }
+    })
+}
+
+func TestNetlink_EnsureRouteIsExactMatch(t *testing.T) {
+    withNetNS(t, func() {
+        lo, err := netlink.LinkByName("lo")
+        if err != nil {
+            t.Fatalf("looking up lo: %v", err)
+        }
+        if err := netlink.LinkSetUp(lo); err != nil {
+            t.Fatalf("bringing up lo: %v", err)
+        }
+
+        b := NewNetlink()
+        if err := b.EnsureRoute(reconcile.RouteSpec{Destination: "10.77.0.0/24", Gateway: "127.0.0.1"}); err != nil {
+            skipIfUnsupported(t, err)
+            return
+        }
+
+        routes, err := b.ListRoutes("main")
+        if err != nil {
+            t.Fatalf("ListRoutes: %v", err)
+        }
+
+        var found bool
+        for _, rt := range routes {
+            if rt.Destination == "10.77.0.0/24" {
+                found = true
+            }
+        }
+        if !found {
+            t.Fatalf("ListRoutes = %v, want an entry for 10.77.0.0/24", routes)
+        }
+    })
+}
@@ -0,0 +1,428 @@
+// Package reconcile turns a desired GRE tunnel / static route / ECMP route
+// configuration into the minimal set of changes needed to make the kernel
+// match it, instead of the old delete-everything-and-recreate approach. It
+// diffs kernel state (queried through a Backend) against what was last
+// applied, and only touches entries that actually changed.
+package reconcile
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    krlog "github.com/unredacted/krouter/internal/log"
+)
+
+// TunnelSpec is the desired state of one GRE tunnel.
+type TunnelSpec struct {
+    Name       string
+    LocalIP    string
+    RemoteIP   string
+    TunnelIP   string
+    SubnetMask string
+}
+
+// TunnelState is a GRE tunnel as reported by a Backend's ListTunnels.
+type TunnelState struct {
+    Name     string
+    LocalIP  string
+    RemoteIP string
+}
+
+// RouteSpec is the desired state of one static route. Table is the routing
+// table name or number; an empty Table means the main table.
+type RouteSpec struct {
+    Destination string
+    Gateway     string
+    Table       string
+}
+
+// NexthopSpec is one weighted nexthop within an ECMPSpec or a RouteState.
+type NexthopSpec struct {
+    Dev    string
+    Via    string
+    Weight int
+}
+
+// ECMPSpec is the desired state of one multipath route. Table is the
+// routing table name or number; an empty Table means the main table.
+type ECMPSpec struct {
+    Route    string
+    Table    string
+    Nexthops []NexthopSpec
+}
+
+// RouteState is a route as reported by a Backend's ListRoutes: either a
+// single-gateway route (Gateway set, Nexthops empty) or a multipath one
+// (Nexthops set).
+type RouteState struct {
+    Destination string
+    Gateway     string
+    Nexthops    []NexthopSpec
+}
+
+// Backend applies tunnel and route specs to the kernel and reports what's
+// currently there. reconcile computes what changed; Backend just knows how
+// to apply or query one entry. internal/netlinkbackend provides both a
+// netlink-based implementation and an `ip`-shelling fallback behind this
+// interface.
+type Backend interface {
+    EnsureGRETunnel(spec TunnelSpec) error
+    DeleteTunnel(name string) error
+    ListTunnels() ([]TunnelState, error)
+
+    EnsureRoute(spec RouteSpec) error
+    DeleteRoute(spec RouteSpec) error
+    ListRoutes(table string) ([]RouteState, error)
+
+    EnsureECMPRoute(spec ECMPSpec) error
+    DeleteECMPRoute(spec ECMPSpec) error
+}
+
+// Reconciler diffs desired specs against kernel state and calls the
+// minimal Backend methods to reconcile them. It remembers what it last
+// applied so that unchanged entries are left untouched across reloads; a
+// single Reconciler should be reused for the lifetime of the process. It is
+// not safe for concurrent use.
+type Reconciler struct {
+    backend Backend
+
+    tunnels map[string]TunnelSpec // keyed by name
+    routes  map[string]RouteSpec  // keyed by destination+table
+    ecmp    map[string]ECMPSpec   // keyed by route+table
+}
+
+// New builds a Reconciler that applies changes through backend.
+func New(backend Backend) *Reconciler {
+    return &Reconciler{
+        backend: backend,
+        tunnels: make(map[string]TunnelSpec),
+        routes:  make(map[string]RouteSpec),
+        ecmp:    make(map[string]ECMPSpec),
+    }
+}
+
+func tableOrMain(table string) string {
+    if table == "" {
+        return "main"
+    }
+    return table
+}
+
+// ReconcileTunnels brings the kernel's GRE tunnels in line with desired.
+// Tunnels this Reconciler previously created that are no longer desired are
+// torn down; unchanged tunnels are left alone; tunnels whose local/remote
+// endpoint changed are deleted and recreated, since a GRE device's
+// endpoints can't be rewritten in place. Individual failures are logged and
+// don't stop the rest of the batch from being reconciled.
+//
+// A tunnel this Reconciler never applied (e.g. right after a process
+// restart, when r.tunnels starts empty) but whose kernel-reported endpoints
+// already match desired is adopted rather than deleted and recreated, so a
+// restart doesn't drop traffic on every tunnel that didn't actually change.
+func (r *Reconciler) ReconcileTunnels(desired []TunnelSpec) error {
+    desiredByName := make(map[string]TunnelSpec, len(desired))
+    for _, spec := range desired {
+        desiredByName[spec.Name] = spec
+    }
+
+    existing, err := r.listTunnels()
+    if err != nil {
+        return fmt.Errorf("listing tunnels: %w", err)
+    }
+
+    for name := range r.tunnels {
+        if _, wanted := desiredByName[name]; wanted {
+            continue
+        }
+        if _, present := existing[name]; present {
+            if err := r.backend.DeleteTunnel(name); err != nil {
+                krlog.Errorf("Failed to delete tunnel %s: %v", name, err)
+            } else {
+                krlog.Infof("Removed tunnel: %s", name)
+            }
+        }
+        delete(r.tunnels, name)
+    }
+
+    for _, spec := range desired {
+        prev, wasManaged := r.tunnels[spec.Name]
+        kernelState, present := existing[spec.Name]
+        matchesKernel := present && kernelState.LocalIP == spec.LocalIP && kernelState.RemoteIP == spec.RemoteIP
+
+        if wasManaged && prev == spec && present {
+            continue // unchanged: leave completely untouched
+        }
+
+        if !wasManaged && matchesKernel {
+            r.tunnels[spec.Name] = spec
+            krlog.Infof("Adopted existing tunnel: %s", spec.Name)
+            continue
+        }
+
+        if present {
+            if err := r.backend.DeleteTunnel(spec.Name); err != nil {
+                krlog.Errorf("Failed to delete tunnel %s for update: %v", spec.Name, err)
+                continue
+            }
+        }
+        if err := r.backend.EnsureGRETunnel(spec); err != nil {
+            krlog.Errorf("Failed to configure tunnel %s: %v", spec.Name, err)
+            continue
+        }
+        r.tunnels[spec.Name] = spec
+        krlog.Infof("Configured tunnel: %s", spec.Name)
+    }
+
+    return nil
+}
+
+func (r *Reconciler) listTunnels() (map[string]TunnelState, error) {
+    states, err := r.backend.ListTunnels()
+    if err != nil {
+        return nil, err
+    }
+    existing := make(map[string]TunnelState, len(states))
+    for _, s := range states {
+        existing[s.Name] = s
+    }
+    return existing, nil
+}
+
+func routeKey(destination, table string) string {
+    return destination + "@" + tableOrMain(table)
+}
+
+// ReconcileRoutes brings the kernel's static routes in line with desired,
+// keyed by the exact (destination, table) pair rather than a substring
+// match against `ip route show` output.
+func (r *Reconciler) ReconcileRoutes(desired []RouteSpec) error {
+    desiredByKey := make(map[string]RouteSpec, len(desired))
+    for _, spec := range desired {
+        desiredByKey[routeKey(spec.Destination, spec.Table)] = spec
+    }
+
+    for key, prev := range r.routes {
+        if _, wanted := desiredByKey[key]; wanted {
+            continue
+        }
+        if err := r.backend.DeleteRoute(prev); err != nil {
+            krlog.Errorf("Failed to delete static route %s (table %s): %v", prev.Destination, tableOrMain(prev.Table), err)
+        } else {
+            krlog.Infof("Removed static route: %s (table %s)", prev.Destination, tableOrMain(prev.Table))
+        }
+        delete(r.routes, key)
+    }
+
+    // Routes in desired are usually concentrated in a handful of tables, so
+    // ListRoutes is called once per distinct table rather than once per
+    // spec, keeping a reload's kernel route-table dumps O(tables) instead of
+    // O(routes).
+    byTable := make(map[string][]RouteState)
+    for _, spec := range desired {
+        table := tableOrMain(spec.Table)
+        if _, cached := byTable[table]; cached {
+            continue
+        }
+        routes, err := r.backend.ListRoutes(table)
+        if err != nil {
+            return fmt.Errorf("listing routes in table %s: %w", table, err)
+        }
+        byTable[table] = routes
+    }
+
+    for _, spec := range desired {
+        key := routeKey(spec.Destination, spec.Table)
+        table := tableOrMain(spec.Table)
+
+        prev, wasManaged := r.routes[key]
+        gateway, present := routeLookup(byTable[table], spec.Destination)
+
+        if wasManaged && prev == spec && present && gateway == spec.Gateway {
+            continue // unchanged
+        }
+
+        if err := r.backend.EnsureRoute(spec); err != nil {
+            krlog.Errorf("Failed to add static route %s via %s: %v", spec.Destination, spec.Gateway, err)
+            continue
+        }
+        krlog.Infof("Added static route: %s via %s", spec.Destination, spec.Gateway)
+        r.routes[key] = spec
+    }
+
+    return nil
+}
+
+func routeLookup(routes []RouteState, destination string) (gateway string, present bool) {
+    for _, rt := range routes {
+        if rt.Destination == destination {
+            return rt.Gateway, true
+        }
+    }
+    return "", false
+}
+
+func sortedNexthops(nexthops []NexthopSpec) []NexthopSpec {
+    sorted := make([]NexthopSpec, len(nexthops))
+    copy(sorted, nexthops)
+    sort.Slice(sorted, func(i, j int) bool {
+        if sorted[i].Dev != sorted[j].Dev {
+            return sorted[i].Dev < sorted[j].Dev
+        }
+        return sorted[i].Via < sorted[j].Via
+    })
+    return sorted
+}
+
+func ecmpKey(route, table string) string {
+    return route + "@" + tableOrMain(table)
+}
+
+func canonicalECMP(spec ECMPSpec) ECMPSpec {
+    spec.Nexthops = sortedNexthops(spec.Nexthops)
+    return spec
+}
+
+// ReconcileECMP brings the kernel's multipath routes in line with desired,
+// keyed by route and table and compared by full sorted nexthop set. A changed nexthop weight or
+// membership rewrites the whole entry with a single EnsureECMPRoute call,
+// since the kernel has no way to patch one nexthop in place. As with
+// ReconcileRoutes, the kernel's own multipath state (not just what this
+// Reconciler last applied) is consulted, so a route edited or removed
+// outside krouter is noticed and restored rather than assumed unchanged.
+func (r *Reconciler) ReconcileECMP(desired []ECMPSpec) error {
+    desiredByKey := make(map[string]ECMPSpec, len(desired))
+    for _, spec := range desired {
+        desiredByKey[ecmpKey(spec.Route, spec.Table)] = canonicalECMP(spec)
+    }
+
+    for key, prev := range r.ecmp {
+        if _, wanted := desiredByKey[key]; wanted {
+            continue
+        }
+        if err := r.backend.DeleteECMPRoute(prev); err != nil {
+            krlog.Errorf("Failed to delete ECMP route %s (table %s): %v", prev.Route, tableOrMain(prev.Table), err)
+        } else {
+            krlog.Infof("Removed ECMP route: %s (table %s)", prev.Route, tableOrMain(prev.Table))
+        }
+        delete(r.ecmp, key)
+    }
+
+    // ECMP routes in desired are usually concentrated in a handful of
+    // tables, so ListRoutes is called once per distinct table rather than
+    // once per spec, same as ReconcileRoutes.
+    byTable := make(map[string][]RouteState)
+    for _, spec := range desiredByKey {
+        table := tableOrMain(spec.Table)
+        if _, cached := byTable[table]; cached {
+            continue
+        }
+        routes, err := r.backend.ListRoutes(table)
+        if err != nil {
+            return fmt.Errorf("listing routes in table %s: %w", table, err)
+        }
+        byTable[table] = routes
+    }
+
+    for key, spec := range desiredByKey {
+        table := tableOrMain(spec.Table)
+        prev, wasManaged := r.ecmp[key]
+        kernelNexthops, present := ecmpLookup(byTable[table], spec.Route)
+
+        if wasManaged && sameECMP(prev, spec) && present && sameNexthops(sortedNexthops(kernelNexthops), spec.Nexthops) {
+            continue // unchanged
+        }
+
+        if err := r.backend.EnsureECMPRoute(spec); err != nil {
+            krlog.Errorf("Failed to add ECMP route %s: %v", spec.Route, err)
+            continue
+        }
+        krlog.Infof("Added ECMP route: %s (table %s)", spec.Route, tableOrMain(spec.Table))
+        r.ecmp[key] = spec
+    }
+
+    return nil
+}
+
+// ecmpLookup finds the kernel-reported multipath route for route, if any.
+// Routes with no Nexthops are single-gateway entries reported by the same
+// ListRoutes call and are not ECMP routes.
+func ecmpLookup(routes []RouteState, route string) (nexthops []NexthopSpec, present bool) {
+    for _, rt := range routes {
+        if rt.Destination == route && len(rt.Nexthops) > 0 {
+            return rt.Nexthops, true
+        }
+    }
+    return nil, false
+}
+
+func sameECMP(a, b ECMPSpec) bool {
+    if a.Route != b.Route || tableOrMain(a.Table) != tableOrMain(b.Table) {
+        return false
+    }
+    return sameNexthops(a.Nexthops, b.Nexthops)
+}
+
+// sameNexthops compares two already-sorted nexthop sets for equality.
+func sameNexthops(a, b []NexthopSpec) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// Teardown removes every tunnel, route and ECMP route this Reconciler has
+// applied, best-effort: it logs and keeps going on individual failures
+// rather than aborting partway through. Callers that need a bounded
+// teardown (e.g. on SIGTERM) should run it in a goroutine and enforce their
+// own timeout, since a hung Backend call would otherwise block forever.
+func (r *Reconciler) Teardown() {
+    for name := range r.tunnels {
+        if err := r.backend.DeleteTunnel(name); err != nil {
+            krlog.Errorf("Teardown: failed to delete tunnel %s: %v", name, err)
+        }
+    }
+    r.tunnels = make(map[string]TunnelSpec)
+
+    for key, spec := range r.routes {
+        if err := r.backend.DeleteRoute(spec); err != nil {
+            krlog.Errorf("Teardown: failed to delete route %s: %v", spec.Destination, err)
+        }
+        delete(r.routes, key)
+    }
+
+    for key, spec := range r.ecmp {
+        if err := r.backend.DeleteECMPRoute(spec); err != nil {
+            krlog.Errorf("Teardown: failed to delete ECMP route %s: %v", spec.Route, err)
+        }
+        delete(r.ecmp, key)
+    }
+}
+
+// Dump returns a human-readable summary of every tunnel and route this
+// Reconciler currently believes it has applied, for SIGUSR1 debugging.
+func (r *Reconciler) Dump() string {
+    var b strings.Builder
+
+    fmt.Fprintf(&b, "tunnels (%d):\n", len(r.tunnels))
+    for name, t := range r.tunnels {
+        fmt.Fprintf(&b, "  %s: local=%s remote=%s addr=%s/%s\n", name, t.LocalIP, t.RemoteIP, t.TunnelIP, t.SubnetMask)
+    }
+
+    fmt.Fprintf(&b, "routes (%d):\n", len(r.routes))
+    for key, spec := range r.routes {
+        fmt.Fprintf(&b, "  %s: via %s (table %s)\n", key, spec.Gateway, tableOrMain(spec.Table))
+    }
+
+    fmt.Fprintf(&b, "ecmp routes (%d):\n", len(r.ecmp))
+    for key, spec := range r.ecmp {
+        fmt.Fprintf(&b, "  %s: %v (table %s)\n", key, spec.Nexthops, tableOrMain(spec.Table))
+    }
+
+    return b.String()
+}
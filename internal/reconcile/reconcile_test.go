@@ -0,0 +1,270 @@
+package reconcile
+
+import (
+    "reflect"
+    "testing"
+)
+
+// fakeBackend records every call made to it and lets tests script
+// ListTunnels/ListRoutes responses, so a Reconcile* call can be asserted
+// against the exact sequence of Backend calls it issues.
+type fakeBackend struct {
+    calls []string
+
+    tunnels []TunnelState
+    routes  map[string][]RouteState // keyed by table
+}
+
+func newFakeBackend() *fakeBackend {
+    return &fakeBackend{routes: make(map[string][]RouteState)}
+}
+
+func (f *fakeBackend) EnsureGRETunnel(spec TunnelSpec) error {
+    f.calls = append(f.calls, "EnsureGRETunnel:"+spec.Name)
+    return nil
+}
+
+func (f *fakeBackend) DeleteTunnel(name string) error {
+    f.calls = append(f.calls, "DeleteTunnel:"+name)
+    return nil
+}
+
+func (f *fakeBackend) ListTunnels() ([]TunnelState, error) {
+    return f.tunnels, nil
+}
+
+func (f *fakeBackend) EnsureRoute(spec RouteSpec) error {
+    f.calls = append(f.calls, "EnsureRoute:"+spec.Destination+"->"+spec.Gateway)
+    return nil
+}
+
+func (f *fakeBackend) DeleteRoute(spec RouteSpec) error {
+    f.calls = append(f.calls, "DeleteRoute:"+spec.Destination)
+    return nil
+}
+
+func (f *fakeBackend) ListRoutes(table string) ([]RouteState, error) {
+    return f.routes[tableOrMain(table)], nil
+}
+
+func (f *fakeBackend) EnsureECMPRoute(spec ECMPSpec) error {
+    f.calls = append(f.calls, "EnsureECMPRoute:"+spec.Route)
+    return nil
+}
+
+func (f *fakeBackend) DeleteECMPRoute(spec ECMPSpec) error {
+    f.calls = append(f.calls, "DeleteECMPRoute:"+spec.Route)
+    return nil
+}
+
+func TestReconcileTunnels_CreatesNewTunnel(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+
+    err := r.ReconcileTunnels([]TunnelSpec{
+        {Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2", TunnelIP: "192.168.1.1", SubnetMask: "30"},
+    })
+    if err != nil {
+        t.Fatalf("ReconcileTunnels: %v", err)
+    }
+
+    want := []string{"EnsureGRETunnel:gre1"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
+
+func TestReconcileTunnels_LeavesUnchangedTunnelAlone(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+    spec := TunnelSpec{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2", TunnelIP: "192.168.1.1", SubnetMask: "30"}
+
+    if err := r.ReconcileTunnels([]TunnelSpec{spec}); err != nil {
+        t.Fatalf("first reconcile: %v", err)
+    }
+    backend.calls = nil
+    backend.tunnels = []TunnelState{{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2"}}
+
+    if err := r.ReconcileTunnels([]TunnelSpec{spec}); err != nil {
+        t.Fatalf("second reconcile: %v", err)
+    }
+
+    if len(backend.calls) != 0 {
+        t.Fatalf("expected no calls on an unchanged reconcile, got %v", backend.calls)
+    }
+}
+
+func TestReconcileTunnels_RecreatesChangedTunnel(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+
+    if err := r.ReconcileTunnels([]TunnelSpec{
+        {Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2", TunnelIP: "192.168.1.1", SubnetMask: "30"},
+    }); err != nil {
+        t.Fatalf("first reconcile: %v", err)
+    }
+    backend.calls = nil
+    backend.tunnels = []TunnelState{{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2"}}
+
+    if err := r.ReconcileTunnels([]TunnelSpec{
+        {Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.9", TunnelIP: "192.168.1.1", SubnetMask: "30"},
+    }); err != nil {
+        t.Fatalf("second reconcile: %v", err)
+    }
+
+    want := []string{"DeleteTunnel:gre1", "EnsureGRETunnel:gre1"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
+
+func TestReconcileTunnels_RemovesDroppedTunnel(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+    spec := TunnelSpec{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2", TunnelIP: "192.168.1.1", SubnetMask: "30"}
+
+    if err := r.ReconcileTunnels([]TunnelSpec{spec}); err != nil {
+        t.Fatalf("first reconcile: %v", err)
+    }
+    backend.calls = nil
+    backend.tunnels = []TunnelState{{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2"}}
+
+    if err := r.ReconcileTunnels(nil); err != nil {
+        t.Fatalf("second reconcile: %v", err)
+    }
+
+    want := []string{"DeleteTunnel:gre1"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
+
+func TestReconcileTunnels_AdoptsMatchingTunnelOnRestart(t *testing.T) {
+    // Regression test: a fresh Reconciler (as after a process restart) has
+    // an empty r.tunnels, so wasManaged is never true on the first pass.
+    // If the kernel already has a tunnel matching desired, it must be
+    // adopted rather than torn down and recreated.
+    backend := newFakeBackend()
+    backend.tunnels = []TunnelState{{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2"}}
+    r := New(backend)
+
+    spec := TunnelSpec{Name: "gre1", LocalIP: "10.0.0.1", RemoteIP: "10.0.0.2", TunnelIP: "192.168.1.1", SubnetMask: "30"}
+    if err := r.ReconcileTunnels([]TunnelSpec{spec}); err != nil {
+        t.Fatalf("ReconcileTunnels: %v", err)
+    }
+
+    if len(backend.calls) != 0 {
+        t.Fatalf("expected no calls when adopting a matching tunnel, got %v", backend.calls)
+    }
+}
+
+func TestReconcileRoutes_AddsMissingRoute(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+
+    err := r.ReconcileRoutes([]RouteSpec{{Destination: "10.1.0.0/24", Gateway: "10.0.0.1"}})
+    if err != nil {
+        t.Fatalf("ReconcileRoutes: %v", err)
+    }
+
+    want := []string{"EnsureRoute:10.1.0.0/24->10.0.0.1"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
+
+func TestReconcileRoutes_DoesNotMatchNarrowerSubnet(t *testing.T) {
+    // The old routeExists used a substring match against the route table
+    // dump; presence is now decided by exact destination, so a kernel route
+    // for "10.0.0.0/8" must not count as "10.0.0.0/24" being present.
+    backend := newFakeBackend()
+    backend.routes["main"] = []RouteState{{Destination: "10.0.0.0/8", Gateway: "10.0.0.1"}}
+    r := New(backend)
+
+    if err := r.ReconcileRoutes([]RouteSpec{{Destination: "10.0.0.0/24", Gateway: "10.0.0.1"}}); err != nil {
+        t.Fatalf("ReconcileRoutes: %v", err)
+    }
+
+    want := []string{"EnsureRoute:10.0.0.0/24->10.0.0.1"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
+
+func TestReconcileECMP_ReplacesOnWeightChange(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+
+    spec := ECMPSpec{
+        Route: "10.2.0.0/24",
+        Nexthops: []NexthopSpec{
+            {Dev: "eth0", Via: "10.0.0.1", Weight: 1},
+            {Dev: "eth1", Via: "10.0.0.2", Weight: 1},
+        },
+    }
+    if err := r.ReconcileECMP([]ECMPSpec{spec}); err != nil {
+        t.Fatalf("first reconcile: %v", err)
+    }
+    backend.calls = nil
+    backend.routes["main"] = []RouteState{{Destination: spec.Route, Nexthops: sortedNexthops(spec.Nexthops)}}
+
+    spec.Nexthops[0].Weight = 5
+    if err := r.ReconcileECMP([]ECMPSpec{spec}); err != nil {
+        t.Fatalf("second reconcile: %v", err)
+    }
+
+    want := []string{"EnsureECMPRoute:10.2.0.0/24"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
+
+func TestReconcileECMP_UnchangedIsNoOp(t *testing.T) {
+    backend := newFakeBackend()
+    r := New(backend)
+
+    spec := ECMPSpec{
+        Route:    "10.2.0.0/24",
+        Nexthops: []NexthopSpec{{Dev: "eth0", Via: "10.0.0.1", Weight: 1}},
+    }
+    if err := r.ReconcileECMP([]ECMPSpec{spec}); err != nil {
+        t.Fatalf("first reconcile: %v", err)
+    }
+    backend.calls = nil
+    backend.routes["main"] = []RouteState{{Destination: spec.Route, Nexthops: sortedNexthops(spec.Nexthops)}}
+
+    if err := r.ReconcileECMP([]ECMPSpec{spec}); err != nil {
+        t.Fatalf("second reconcile: %v", err)
+    }
+    if len(backend.calls) != 0 {
+        t.Fatalf("expected no calls on unchanged ECMP route, got %v", backend.calls)
+    }
+}
+
+func TestReconcileECMP_RestoresRouteChangedOutsideKrouter(t *testing.T) {
+    // Regression test: if something other than krouter edits or removes a
+    // multipath route, ReconcileECMP must notice on the next pass instead
+    // of trusting its in-memory map that nothing changed.
+    backend := newFakeBackend()
+    r := New(backend)
+
+    spec := ECMPSpec{
+        Route:    "10.2.0.0/24",
+        Nexthops: []NexthopSpec{{Dev: "eth0", Via: "10.0.0.1", Weight: 1}},
+    }
+    if err := r.ReconcileECMP([]ECMPSpec{spec}); err != nil {
+        t.Fatalf("first reconcile: %v", err)
+    }
+    backend.calls = nil
+    // Kernel now reports the route gone, as if something external removed it.
+    backend.routes["main"] = nil
+
+    if err := r.ReconcileECMP([]ECMPSpec{spec}); err != nil {
+        t.Fatalf("second reconcile: %v", err)
+    }
+
+    want := []string{"EnsureECMPRoute:10.2.0.0/24"}
+    if !reflect.DeepEqual(backend.calls, want) {
+        t.Fatalf("calls = %v, want %v", backend.calls, want)
+    }
+}
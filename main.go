@@ -1,202 +1,636 @@
 package main
 
 import (
+    "context"
     "crypto/md5"
     "encoding/hex"
-    "strconv"
+    "fmt"
+    "net"
+    "path/filepath"
+    "sort"
     "strings"
     "gopkg.in/yaml.v2"
     "io/ioutil"
     "log"
+    "net/http"
     "os"
-    "os/exec"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
     "github.com/fsnotify/fsnotify"
-	"bufio"
     "bytes"
+
+    krlog "github.com/unredacted/krouter/internal/log"
+    "github.com/unredacted/krouter/internal/log/rotate"
+    "github.com/unredacted/krouter/internal/healthcheck"
+    "github.com/unredacted/krouter/internal/metrics"
+    "github.com/unredacted/krouter/internal/netlinkbackend"
+    "github.com/unredacted/krouter/internal/reconcile"
+)
+
+// teardownTimeout bounds how long graceful shutdown waits for `ip` to tear
+// down tunnels and routes before giving up and exiting anyway.
+const teardownTimeout = 5 * time.Second
+
+// defaultConfDir is used when ProgramSettings.ConfDir is unset.
+const defaultConfDir = "/etc/krouter/conf.d"
+
+// defaultMetricsAddr is used when ProgramSettings.Metrics.Addr is unset.
+const defaultMetricsAddr = ":9100"
+
+// Defaults applied to an ECMPNexthop's HealthCheck when its interval,
+// timeout or thresholds are unset.
+const (
+    defaultHealthCheckIntervalSeconds = 5
+    defaultHealthCheckTimeoutSeconds  = 2
+    defaultHealthCheckThreshold       = 1
 )
 
+// LoggingSettings controls the verbosity, format and destination of
+// krouter's logs.
+type LoggingSettings struct {
+    // Level is the preferred way to set verbosity: one of
+    // trace|debug|info|warn|error.
+    Level string `yaml:"level"`
+    // Format selects the console/file encoding: "text" (default) or "json"
+    // for shipping to log aggregators.
+    Format string `yaml:"format"`
+    // Info, Error and Debug are deprecated in favor of Level and are only
+    // consulted when Level is empty.
+    Info  bool `yaml:"info"`
+    Error bool `yaml:"error"`
+    Debug bool `yaml:"debug"`
+}
+
+// LogRotationSettings controls size- and age-based rotation of the log file
+// configured via ProgramSettings.LogFilePath.
+type LogRotationSettings struct {
+    MaxSizeMB  int  `yaml:"max_size_mb"`
+    MaxAgeDays int  `yaml:"max_age_days"`
+    MaxBackups int  `yaml:"max_backups"`
+    Compress   bool `yaml:"compress"`
+}
+
+// ProgramSettings holds process-wide options that aren't tied to a specific
+// tunnel or route.
+type ProgramSettings struct {
+    LogFilePath string              `yaml:"log_file_path"`
+    Logging     LoggingSettings     `yaml:"logging"`
+    LogRotation LogRotationSettings `yaml:"log_rotation"`
+    // ConfDir is scanned for *.yml drop-in files that are merged into the
+    // config loaded from the main config file. Defaults to defaultConfDir.
+    ConfDir string `yaml:"conf_d_dir"`
+    // CleanupOnExit controls whether SIGTERM/SIGINT tear down every tunnel
+    // and route this process installed. Defaults to true; a pointer so an
+    // absent key is distinguishable from an explicit false.
+    CleanupOnExit *bool `yaml:"cleanup_on_exit"`
+    // Backend selects how tunnels and routes are applied: "netlink" (direct
+    // kernel calls, no fork-per-operation) or "iproute2" (shells out to the
+    // `ip` binary). Defaults to "iproute2" so existing deployments don't
+    // change behavior on upgrade.
+    Backend string `yaml:"backend"`
+    // Metrics controls the optional Prometheus /metrics HTTP endpoint.
+    Metrics MetricsSettings `yaml:"metrics"`
+}
+
+// MetricsSettings controls the Prometheus /metrics HTTP endpoint exposing
+// nexthop health-check state.
+type MetricsSettings struct {
+    Enabled bool   `yaml:"enabled"`
+    Addr    string `yaml:"addr"` // defaults to defaultMetricsAddr
+}
+
+// cleanupOnExit reports whether graceful shutdown should tear down managed
+// tunnels and routes, honoring ProgramSettings.CleanupOnExit's default of
+// true.
+func (s ProgramSettings) cleanupOnExit() bool {
+    if s.CleanupOnExit == nil {
+        return true
+    }
+    return *s.CleanupOnExit
+}
+
+type GRETunnel struct {
+    Name       string `yaml:"name"`
+    LocalIP    string `yaml:"local_ip"`
+    RemoteIP   string `yaml:"remote_ip"`
+    TunnelIP   string `yaml:"tunnel_ip"`
+    SubnetMask string `yaml:"subnet_mask"`
+}
+
+type StaticRoute struct {
+    Destination string `yaml:"destination"`
+    Gateway     string `yaml:"gateway"`
+}
+
+type ECMPNexthop struct {
+    Dev    string `yaml:"dev"`
+    Via    string `yaml:"via"`
+    Weight int    `yaml:"weight"`
+    // HealthCheck, if set, removes this nexthop from the kernel's ECMP
+    // entry when it's unhealthy and restores it on recovery.
+    HealthCheck *HealthCheckSettings `yaml:"health_check"`
+}
+
+// HealthCheckSettings configures a periodic probe of one ECMP nexthop.
+type HealthCheckSettings struct {
+    // Type is icmp or tcp. bfd is reserved for future use and is rejected
+    // by validateConfig since there's no working prober for it yet.
+    Type string `yaml:"type"`
+    // Target is the address probed; defaults to the nexthop's Via gateway.
+    Target string `yaml:"target"`
+    // IntervalSeconds and TimeoutSeconds default to
+    // defaultHealthCheckIntervalSeconds and defaultHealthCheckTimeoutSeconds
+    // when unset (zero or negative).
+    IntervalSeconds int `yaml:"interval_seconds"`
+    TimeoutSeconds  int `yaml:"timeout_seconds"`
+    // FailureThreshold and SuccessThreshold default to 1 when unset: a
+    // single failed or successful probe flips the nexthop's health.
+    FailureThreshold int `yaml:"failure_threshold"`
+    SuccessThreshold int `yaml:"success_threshold"`
+}
+
+type ECMPRoute struct {
+    Route    string        `yaml:"route"`
+    Table    string        `yaml:"table"`
+    Nexthops []ECMPNexthop `yaml:"nexthops"`
+}
+
+// toCheck builds a healthcheck.Check from hc, applying defaults for any
+// unset interval, timeout or threshold, and falling back to via for an
+// unset Target.
+func (hc HealthCheckSettings) toCheck(via string) healthcheck.Check {
+    interval := hc.IntervalSeconds
+    if interval <= 0 {
+        interval = defaultHealthCheckIntervalSeconds
+    }
+    timeout := hc.TimeoutSeconds
+    if timeout <= 0 {
+        timeout = defaultHealthCheckTimeoutSeconds
+    }
+    failureThreshold := hc.FailureThreshold
+    if failureThreshold <= 0 {
+        failureThreshold = defaultHealthCheckThreshold
+    }
+    successThreshold := hc.SuccessThreshold
+    if successThreshold <= 0 {
+        successThreshold = defaultHealthCheckThreshold
+    }
+    target := hc.Target
+    if target == "" {
+        target = via
+    }
+    return healthcheck.Check{
+        Type:             hc.Type,
+        Target:           target,
+        Interval:         time.Duration(interval) * time.Second,
+        Timeout:          time.Duration(timeout) * time.Second,
+        FailureThreshold: failureThreshold,
+        SuccessThreshold: successThreshold,
+    }
+}
+
 type Config struct {
-    ProgramSettings struct {
-        LogFilePath string `yaml:"log_file_path"`
-        Logging struct {
-            Info  bool `yaml:"info"`
-            Error bool `yaml:"error"`
-            Debug bool `yaml:"debug"`
-        } `yaml:"logging"`
-    } `yaml:"program_settings"`
-    GRETunnels []struct {
-        Name      string `yaml:"name"`
-        LocalIP   string `yaml:"local_ip"`
-        RemoteIP  string `yaml:"remote_ip"`
-        TunnelIP  string `yaml:"tunnel_ip"`
-        SubnetMask string `yaml:"subnet_mask"`
-    } `yaml:"gre_tunnels"`
-    StaticRoutes []struct {
-        Destination string `yaml:"destination"`
-        Gateway     string `yaml:"gateway"`
-    } `yaml:"static_routes"`
-    ECMPRoutes []struct {
-        Route    string `yaml:"route"`
-        Table    string `yaml:"table"`
-        Nexthops []struct {
-            Dev    string `yaml:"dev"`
-            Via    string `yaml:"via"`
-            Weight int    `yaml:"weight"`
-        } `yaml:"nexthops"`
-    } `yaml:"ecmp_routes"`
+    ProgramSettings ProgramSettings `yaml:"program_settings"`
+    GRETunnels      []GRETunnel     `yaml:"gre_tunnels"`
+    StaticRoutes    []StaticRoute   `yaml:"static_routes"`
+    ECMPRoutes      []ECMPRoute     `yaml:"ecmp_routes"`
 }
 
 var (
     currentHash string
-    logger      *log.Logger
     config      Config
+    // reconciler is built once in main, after the initial config load picks
+    // a backend, and reused across reloads so it remembers what it last
+    // applied, leaving unchanged tunnels and routes untouched instead of
+    // tearing everything down every reload.
+    reconciler *reconcile.Reconciler
+    // reconcileMu serializes calls into reconciler: both the config watcher
+    // and health-check transitions call setupECMPRoutes, and the reconciler
+    // isn't safe for concurrent use.
+    reconcileMu sync.Mutex
+    // healthMgr runs the configured ECMP nexthop health checks and calls
+    // onHealthTransition when one flips, to re-reconcile ECMP routes with
+    // the unhealthy nexthop removed. Built in main, since onHealthTransition
+    // itself calls back into healthMgr.
+    healthMgr *healthcheck.Manager
+    // metricsReg backs the optional /metrics endpoint with nexthop health
+    // counters.
+    metricsReg = metrics.NewRegistry()
 )
 
-func initLogger(logFilePath string, infoEnabled, errorEnabled, debugEnabled bool) {
-    logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// initLogger builds the package-level krlog.Logger from ProgramSettings. It
+// prefers Logging.Level and falls back to the deprecated info/error/debug
+// booleans when Level is unset, so existing config.yml files keep working
+// unchanged.
+func initLogger(settings ProgramSettings) {
+    var level krlog.Level
+    if settings.Logging.Level != "" {
+        level = krlog.ParseLevel(settings.Logging.Level)
+    } else {
+        level = krlog.LevelFromLegacyFlags(settings.Logging.Info, false, settings.Logging.Error, settings.Logging.Debug)
+    }
+    jsonLogging := strings.EqualFold(settings.Logging.Format, "json")
+
+    logFile, err := rotate.Open(settings.LogFilePath, rotate.Options{
+        MaxSizeMB:  settings.LogRotation.MaxSizeMB,
+        MaxAgeDays: settings.LogRotation.MaxAgeDays,
+        MaxBackups: settings.LogRotation.MaxBackups,
+        Compress:   settings.LogRotation.Compress,
+    })
     if err != nil {
         log.Fatalf("Failed to open log file: %v", err)
     }
-    logger = log.New(logFile, "GRE-Manager: ", log.LstdFlags|log.Lshortfile)
-    logger.SetOutput(logWriter{log.New(os.Stdout, "", 0), log.New(logFile, "", 0), infoEnabled, errorEnabled, debugEnabled})
-}
 
-type logWriter struct {
-    stdoutLogger *log.Logger
-    fileLogger   *log.Logger
-    infoEnabled  bool
-    errorEnabled bool
-    debugEnabled bool
+    krlog.SetDefault(krlog.New(krlog.Config{
+        Level:   level,
+        JSON:    jsonLogging,
+        Color:   !jsonLogging && krlog.IsTTY(os.Stdout),
+        Console: os.Stdout,
+        File:    logFile,
+    }))
 }
 
-func (l logWriter) Write(p []byte) (n int, err error) {
-    message := string(p)
-    if l.infoEnabled || l.errorEnabled || l.debugEnabled {
-        l.stdoutLogger.Print(message) // Print to stdout
-        err = l.fileLogger.Output(2, message) // Also log to file
+// setupGRETunnels, setupStaticRoutes and setupECMPRoutes all read the
+// package-level config and call methods on the package-level reconciler,
+// which per its own doc comment isn't safe for concurrent use. Callers must
+// hold reconcileMu for the duration of the call; reconcileAll,
+// reconcileECMP and main's initial setup are the only callers and do so.
+
+// setupGRETunnels reconciles the kernel's GRE tunnels against config,
+// leaving unchanged tunnels untouched instead of deleting and recreating
+// everything on every reload.
+func setupGRETunnels() error {
+    specs := make([]reconcile.TunnelSpec, 0, len(config.GRETunnels))
+    for _, tunnel := range config.GRETunnels {
+        specs = append(specs, reconcile.TunnelSpec{
+            Name:       tunnel.Name,
+            LocalIP:    tunnel.LocalIP,
+            RemoteIP:   tunnel.RemoteIP,
+            TunnelIP:   tunnel.TunnelIP,
+            SubnetMask: tunnel.SubnetMask,
+        })
     }
-    return len(p), err // Return the length of p and the error
+    return reconciler.ReconcileTunnels(specs)
 }
 
-func execCommand(command string, args ...string) (string, error) {
-    cmd := exec.Command(command, args...)
-    var out bytes.Buffer
-    cmd.Stdout = &out
-    cmd.Stderr = os.Stderr
-    err := cmd.Run()
-    return out.String(), err
+// setupStaticRoutes reconciles the kernel's static routes against config.
+func setupStaticRoutes() error {
+    specs := make([]reconcile.RouteSpec, 0, len(config.StaticRoutes))
+    for _, route := range config.StaticRoutes {
+        specs = append(specs, reconcile.RouteSpec{
+            Destination: route.Destination,
+            Gateway:     route.Gateway,
+        })
+    }
+    return reconciler.ReconcileRoutes(specs)
 }
 
-func tunnelExists(name string) bool {
-    output, _ := execCommand("ip", "tunnel", "show")
-    return strings.Contains(output, name)
+// setupECMPRoutes reconciles the kernel's multipath routes against config,
+// keyed by route and table and compared by full sorted nexthop set. Nexthops with a failing
+// health check are dropped from the entry (unless that would empty it
+// entirely, in which case all nexthops are kept as a fail-safe).
+func setupECMPRoutes() error {
+    specs := make([]reconcile.ECMPSpec, 0, len(config.ECMPRoutes))
+    for _, ecmp := range config.ECMPRoutes {
+        nexthops := make([]reconcile.NexthopSpec, 0, len(ecmp.Nexthops))
+        for _, nh := range ecmp.Nexthops {
+            if nh.HealthCheck != nil && !healthMgr.IsHealthy(ecmp.Route, nh.Dev, nh.Via) {
+                continue
+            }
+            nexthops = append(nexthops, reconcile.NexthopSpec{Dev: nh.Dev, Via: nh.Via, Weight: nh.Weight})
+        }
+        if len(nexthops) == 0 && len(ecmp.Nexthops) > 0 {
+            krlog.Warnf("ECMP route %s: every nexthop is unhealthy, keeping all of them rather than installing an empty route", ecmp.Route)
+            for _, nh := range ecmp.Nexthops {
+                nexthops = append(nexthops, reconcile.NexthopSpec{Dev: nh.Dev, Via: nh.Via, Weight: nh.Weight})
+            }
+        }
+        specs = append(specs, reconcile.ECMPSpec{Route: ecmp.Route, Table: ecmp.Table, Nexthops: nexthops})
+    }
+    return reconciler.ReconcileECMP(specs)
 }
 
-func setupGRETunnels() error {
-    for _, tunnel := range config.GRETunnels {
-        if tunnelExists(tunnel.Name) {
-            _, err := execCommand("ip", "tunnel", "del", tunnel.Name)
-            if err != nil {
-                logger.Printf("Failed to delete tunnel %s: %v", tunnel.Name, err)
+// healthCheckTargets returns the healthcheck.Target for every ECMP nexthop
+// in config that has a health_check configured.
+func healthCheckTargets() []healthcheck.Target {
+    var targets []healthcheck.Target
+    for _, ecmp := range config.ECMPRoutes {
+        for _, nh := range ecmp.Nexthops {
+            if nh.HealthCheck == nil {
+                continue
             }
+            targets = append(targets, healthcheck.Target{
+                Route: ecmp.Route,
+                Dev:   nh.Dev,
+                Via:   nh.Via,
+                Check: nh.HealthCheck.toCheck(nh.Via),
+            })
         }
+    }
+    return targets
+}
 
-        _, err := execCommand("ip", "tunnel", "add", tunnel.Name, "mode", "gre", "local", tunnel.LocalIP, "remote", tunnel.RemoteIP)
-        if err != nil {
-            return err
+// onHealthTransition is healthMgr's TransitionFunc: it records the
+// transition in metricsReg and re-reconciles ECMP routes so the kernel's
+// nexthop set reflects the new health state. It runs on a monitor's own
+// goroutine, concurrently with reloadConfig on the fsnotify/SIGHUP
+// goroutines, so it reconciles through reconcileECMP rather than calling
+// setupECMPRoutes directly.
+func onHealthTransition(t healthcheck.Target, healthy bool) {
+    labels := map[string]string{"tunnel": t.Route, "nexthop": t.Dev + "@" + t.Via}
+    up := 0.0
+    if healthy {
+        up = 1.0
+    }
+    metricsReg.SetGauge("krouter_nexthop_up", "1 if a health-checked ECMP nexthop is currently healthy, else 0", labels, up)
+    metricsReg.IncCounter("krouter_nexthop_transitions_total", "Total number of times a health-checked ECMP nexthop has changed state", labels, 1)
+
+    if err := reconcileECMP(); err != nil {
+        krlog.Errorf("Error re-reconciling ECMP routes after health transition: %v", err)
+    }
+}
+
+// startMetricsServer starts the Prometheus /metrics HTTP endpoint if
+// enabled, on its own goroutine.
+func startMetricsServer(settings MetricsSettings) {
+    if !settings.Enabled {
+        return
+    }
+    addr := settings.Addr
+    if addr == "" {
+        addr = defaultMetricsAddr
+    }
+
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", metricsReg.Handler())
+
+    go func() {
+        if err := http.ListenAndServe(addr, mux); err != nil {
+            krlog.Errorf("Metrics server on %s exited: %v", addr, err)
         }
-        _, err = execCommand("ip", "addr", "add", tunnel.TunnelIP+"/"+tunnel.SubnetMask, "dev", tunnel.Name)
-        if err != nil {
-            return err
+    }()
+    krlog.Infof("Metrics server listening on %s", addr)
+}
+
+// mergeConfig appends overlay's tunnels and routes onto base, rejecting
+// GRE tunnel names or (destination, gateway) pairs that already exist.
+// sourceFile is named in any error so operators can find the offending
+// conf.d snippet.
+func mergeConfig(base *Config, overlay Config, sourceFile string) error {
+    tunnelNames := make(map[string]bool, len(base.GRETunnels))
+    for _, t := range base.GRETunnels {
+        tunnelNames[t.Name] = true
+    }
+    routeKeys := make(map[string]bool, len(base.StaticRoutes))
+    for _, r := range base.StaticRoutes {
+        routeKeys[r.Destination+"->"+r.Gateway] = true
+    }
+
+    for _, t := range overlay.GRETunnels {
+        if tunnelNames[t.Name] {
+            return fmt.Errorf("%s: duplicate gre tunnel %q", sourceFile, t.Name)
         }
-        _, err = execCommand("ip", "link", "set", tunnel.Name, "up")
-        if err != nil {
-            return err
+        tunnelNames[t.Name] = true
+        base.GRETunnels = append(base.GRETunnels, t)
+    }
+
+    for _, r := range overlay.StaticRoutes {
+        key := r.Destination + "->" + r.Gateway
+        if routeKeys[key] {
+            return fmt.Errorf("%s: duplicate static route %s via %s", sourceFile, r.Destination, r.Gateway)
         }
-        logger.Printf("Configured tunnel: %s", tunnel.Name)
+        routeKeys[key] = true
+        base.StaticRoutes = append(base.StaticRoutes, r)
     }
+
+    base.ECMPRoutes = append(base.ECMPRoutes, overlay.ECMPRoutes...)
     return nil
 }
 
-func routeExists(destination, gateway string) bool {
-    output, _ := execCommand("ip", "route", "show")
-    return strings.Contains(output, destination) && strings.Contains(output, gateway)
+// confDropIns returns the sorted list of *.yml files under confDir, or an
+// empty slice if confDir does not exist.
+func confDropIns(confDir string) ([]string, error) {
+    matches, err := filepath.Glob(filepath.Join(confDir, "*.yml"))
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(matches)
+    return matches, nil
 }
 
-func setupStaticRoutes() error {
-    for _, route := range config.StaticRoutes {
-        if !routeExists(route.Destination, route.Gateway) {
-            if _, err := execCommand("ip", "route", "add", route.Destination, "via", route.Gateway); err != nil {
-                logger.Printf("Failed to add static route %s via %s: %v", route.Destination, route.Gateway, err)
-            } else {
-                logger.Printf("Added static route: %s via %s", route.Destination, route.Gateway)
-            }
-        }
+// loadConfig reads filePath, then merges in every *.yml drop-in under the
+// configured conf.d directory (ProgramSettings.ConfDir, defaulting to
+// defaultConfDir) before assigning the result to the package-level config.
+func loadConfig(filePath string) error {
+    data, err := ioutil.ReadFile(filePath)
+    if err != nil {
+        return err
+    }
+
+    var merged Config
+    if err := yaml.Unmarshal(data, &merged); err != nil {
+        return fmt.Errorf("%s: %w", filePath, err)
+    }
+
+    confDir := merged.ProgramSettings.ConfDir
+    if confDir == "" {
+        confDir = defaultConfDir
+    }
+
+    dropIns, err := confDropIns(confDir)
+    if err != nil {
+        return fmt.Errorf("scanning conf.d dir %s: %w", confDir, err)
     }
-    return nil
-}
 
-func ecmpRouteExists(route, table string) bool {
-    output, _ := execCommand("ip", "route", "show", "table", table)
-    scanner := bufio.NewScanner(strings.NewReader(output))
-    for scanner.Scan() {
-        if strings.Contains(scanner.Text(), route) {
-            return true
+    for _, path := range dropIns {
+        fragData, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        var fragment Config
+        if err := yaml.Unmarshal(fragData, &fragment); err != nil {
+            return fmt.Errorf("%s: %w", path, err)
+        }
+        if err := mergeConfig(&merged, fragment, path); err != nil {
+            return err
         }
     }
-    return false
+
+    if err := validateConfig(merged); err != nil {
+        return err
+    }
+
+    config = merged
+    return nil
 }
 
-func setupECMPRoutes() error {
-    for _, ecmp := range config.ECMPRoutes {
-        if !ecmpRouteExists(ecmp.Route, ecmp.Table) {
-            var nexthopArgs []string
-            for _, nh := range ecmp.Nexthops {
-                nexthopArgs = append(nexthopArgs, "nexthop", "dev", nh.Dev, "via", nh.Via, "weight", strconv.Itoa(nh.Weight))
+// validateConfig rejects health_check configurations that would otherwise
+// fail every probe forever in a way indistinguishable from a real outage:
+// bfd, which isn't implemented yet, and tcp checks whose target (after
+// falling back to the nexthop's Via) has no port, since a bare IP can't be
+// dialed as a TCP address.
+func validateConfig(cfg Config) error {
+    for _, ecmp := range cfg.ECMPRoutes {
+        for _, nh := range ecmp.Nexthops {
+            if nh.HealthCheck == nil {
+                continue
             }
-            args := append([]string{"route", "add", ecmp.Route, "proto", "static", "scope", "global", "table", ecmp.Table}, nexthopArgs...)
-            if _, err := execCommand("ip", args...); err != nil {
-                logger.Printf("Failed to add ECMP route %s: %v", ecmp.Route, err)
-            } else {
-                logger.Printf("Added ECMP route: %s", strings.Join(args, " "))
+            switch nh.HealthCheck.Type {
+            case "icmp":
+            case "tcp":
+                target := nh.HealthCheck.Target
+                if target == "" {
+                    target = nh.Via
+                }
+                if _, _, err := net.SplitHostPort(target); err != nil {
+                    return fmt.Errorf("ecmp route %s nexthop %s@%s: tcp health check target %q needs a port, e.g. %q", ecmp.Route, nh.Dev, nh.Via, target, net.JoinHostPort(target, "<port>"))
+                }
+            case "bfd":
+                return fmt.Errorf("ecmp route %s nexthop %s@%s: bfd health checks are not implemented yet", ecmp.Route, nh.Dev, nh.Via)
+            default:
+                return fmt.Errorf("ecmp route %s nexthop %s@%s: unknown health check type %q", ecmp.Route, nh.Dev, nh.Via, nh.HealthCheck.Type)
             }
         }
     }
     return nil
 }
 
-func getFileMD5(filePath string) (string, error) {
-    var md5String string
-    data, err := ioutil.ReadFile(filePath)
+// configSourceFiles returns the main config file followed by every conf.d
+// drop-in currently in effect, in the order their bytes are hashed by
+// getConfigHash.
+func configSourceFiles(filePath string) ([]string, error) {
+    confDir := config.ProgramSettings.ConfDir
+    if confDir == "" {
+        confDir = defaultConfDir
+    }
+    dropIns, err := confDropIns(confDir)
     if err != nil {
-        return md5String, err
+        return nil, err
     }
-    hash := md5.Sum(data)
-    md5String = hex.EncodeToString(hash[:])
-    return md5String, nil
+    return append([]string{filePath}, dropIns...), nil
 }
 
-func loadConfig(filePath string) error {
-    data, err := ioutil.ReadFile(filePath)
+// getConfigHash hashes the concatenation of the main config file and every
+// conf.d drop-in, so editing a snippet flips currentHash just like editing
+// the main file does.
+func getConfigHash(filePath string) (string, error) {
+    files, err := configSourceFiles(filePath)
     if err != nil {
-        return err
+        return "", err
     }
 
-    if err := yaml.Unmarshal(data, &config); err != nil {
-        return err
+    var buf bytes.Buffer
+    for _, f := range files {
+        data, err := ioutil.ReadFile(f)
+        if err != nil {
+            return "", err
+        }
+        buf.Write(data)
     }
 
-    return nil
+    hash := md5.Sum(buf.Bytes())
+    return hex.EncodeToString(hash[:]), nil
+}
+
+// watchesDropIn reports whether event.Name is a *.yml file inside confDir,
+// i.e. a conf.d create/write/remove that should trigger a reload.
+func watchesDropIn(event fsnotify.Event, confDir string) bool {
+    return filepath.Dir(event.Name) == filepath.Clean(confDir) && filepath.Ext(event.Name) == ".yml"
 }
 
-func watchConfigFile(filePath string) {
+// reconcileAll runs all three reconcile passes under reconcileMu and
+// records how long they took in krouter_reconcile_duration_seconds.
+// reconcileMu guards every call into setupGRETunnels/setupStaticRoutes/
+// setupECMPRoutes, since they read the package-level config and drive the
+// package-level reconciler, neither of which tolerates concurrent access;
+// reconcileECMP takes the same lock for the health-check-triggered path so
+// the two can't interleave.
+func reconcileAll() {
+    reconcileMu.Lock()
+    defer reconcileMu.Unlock()
+
+    start := time.Now()
+    if err := setupGRETunnels(); err != nil {
+        krlog.Errorf("Error setting up GRE tunnels: %v", err)
+    }
+    if err := setupStaticRoutes(); err != nil {
+        krlog.Errorf("Error setting up static routes: %v", err)
+    }
+    if err := setupECMPRoutes(); err != nil {
+        krlog.Errorf("Error setting up ECMP routes: %v", err)
+    }
+    metricsReg.SetGauge("krouter_reconcile_duration_seconds", "Duration of the most recent full reconcile pass, in seconds", nil, time.Since(start).Seconds())
+}
+
+// reconcileECMP reconciles only the ECMP routes, under the same
+// reconcileMu as reconcileAll. It's used by onHealthTransition, which
+// drops or restores a single nexthop and doesn't need to touch tunnels or
+// static routes.
+func reconcileECMP() error {
+    reconcileMu.Lock()
+    defer reconcileMu.Unlock()
+    return setupECMPRoutes()
+}
+
+// reloadConfig re-reads filePath (and its conf.d drop-ins) and reconciles
+// the kernel against it. When force is false, a reload is skipped unless
+// the config's hash actually changed; SIGHUP passes force=true so operators
+// can trigger a reload even when fsnotify missed the underlying edit.
+//
+// reloadConfig runs on two independent goroutines: the fsnotify
+// event-handler goroutine started by watchConfigFile, and the signal
+// manager's SIGHUP case. Without synchronization those two could race on
+// currentHash and on the package-level config (loadConfig's assignment
+// racing a concurrent setupGRETunnels/setupStaticRoutes read of its slice
+// fields), so the hash check and load are serialized under reconcileMu.
+// healthMgr.Reload is called outside that lock: it can block waiting for a
+// monitor goroutine to exit, and that goroutine's in-flight probe may be
+// calling back into onHealthTransition, which itself needs reconcileMu.
+func reloadConfig(filePath string, force bool) {
+    targets, changed := func() ([]healthcheck.Target, bool) {
+        reconcileMu.Lock()
+        defer reconcileMu.Unlock()
+
+        newHash, err := getConfigHash(filePath)
+        if err != nil {
+            krlog.Errorf("Error hashing config: %v", err)
+            return nil, false
+        }
+        if !force && newHash == currentHash {
+            return nil, false
+        }
+        currentHash = newHash
+
+        if err := loadConfig(filePath); err != nil {
+            krlog.Errorf("Error loading config: %v", err)
+            return nil, false
+        }
+        return healthCheckTargets(), true
+    }()
+    if !changed {
+        return
+    }
+
+    healthMgr.Reload(targets)
+    reconcileAll()
+}
+
+// watchConfigFile watches filePath and its conf.d directory for changes,
+// reconciling on every relevant event, until ctx is canceled.
+func watchConfigFile(ctx context.Context, filePath string) {
     watcher, err := fsnotify.NewWatcher()
     if err != nil {
-        logger.Fatal(err)
+        krlog.Fatal(err)
     }
     defer watcher.Close()
 
-    done := make(chan bool)
+    confDir := config.ProgramSettings.ConfDir
+    if confDir == "" {
+        confDir = defaultConfDir
+    }
+
     go func() {
         for {
             select {
@@ -204,43 +638,115 @@ func watchConfigFile(filePath string) {
                 if !ok {
                     return
                 }
-                if event.Op&fsnotify.Write == fsnotify.Write {
-                    newHash, err := getFileMD5(filePath)
-                    if err != nil {
-                        logger.Println("Error reading file:", err)
-                        continue
-                    }
-                    if newHash != currentHash {
-                        currentHash = newHash
-                        if err := loadConfig(filePath); err != nil {
-                            logger.Printf("Error loading config: %v", err)
-                            continue
-                        }
-                        if err := setupGRETunnels(); err != nil {
-                            logger.Printf("Error setting up GRE tunnels: %v", err)
-                        }
-                        if err := setupStaticRoutes(); err != nil {
-                            logger.Printf("Error setting up static routes: %v", err)
-                        }
-                        if err := setupECMPRoutes(); err != nil {
-                            logger.Printf("Error setting up ECMP routes: %v", err)
-                        }
-                    }
+                if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+                    continue
+                }
+                if event.Name == filePath || watchesDropIn(event, confDir) {
+                    reloadConfig(filePath, false)
                 }
             case err, ok := <-watcher.Errors:
                 if !ok {
                     return
                 }
-                logger.Println("Error:", err)
+                krlog.Errorf("Error: %v", err)
+            case <-ctx.Done():
+                return
             }
         }
     }()
 
-    err = watcher.Add(filePath)
+    if err := watcher.Add(filePath); err != nil {
+        krlog.Fatal(err)
+    }
+    if err := watcher.Add(confDir); err != nil {
+        krlog.Warnf("Not watching conf.d directory %s: %v", confDir, err)
+    }
+    <-ctx.Done()
+}
+
+// dumpState logs the effective config and the reconciler's view of kernel
+// state, for SIGUSR1-triggered debugging. It takes reconcileMu because
+// config and reconciler are also mutated by reloadConfig/reconcileAll,
+// running concurrently on the fsnotify event-handler goroutine.
+func dumpState(filePath string) {
+    reconcileMu.Lock()
+    defer reconcileMu.Unlock()
+
+    effective, err := yaml.Marshal(config)
     if err != nil {
-        logger.Fatal(err)
+        krlog.Errorf("SIGUSR1: failed to marshal effective config: %v", err)
+    } else {
+        krlog.Infof("SIGUSR1: effective config for %s:\n%s", filePath, effective)
+    }
+    krlog.Infof("SIGUSR1: reconciler state:\n%s", reconciler.Dump())
+}
+
+// teardown removes every tunnel and route this process installed, bounded
+// by teardownTimeout so a hung `ip` invocation can't block shutdown
+// forever. It honors ProgramSettings.CleanupOnExit so operators can opt out
+// for failover scenarios where the routes must stay in place.
+//
+// healthMgr is stopped before reconcileMu is taken: a probe can be
+// mid-flight calling onHealthTransition -> reconcileECMP, which itself
+// needs reconcileMu, and healthMgr.Stop() waits for that probe to finish,
+// so taking the lock first would deadlock against it. Once health checks
+// are stopped, reconcileMu is taken for the rest of teardown for the same
+// reason dumpState does: reconciler.Teardown() mutates the same maps
+// reconcileAll does, and the fsnotify event-handler goroutine is still
+// running (ctx isn't canceled until after teardown returns), so without
+// the lock a reload racing teardown would be a concurrent map read/write.
+func teardown() {
+    healthMgr.Stop()
+
+    reconcileMu.Lock()
+    defer reconcileMu.Unlock()
+
+    if !config.ProgramSettings.cleanupOnExit() {
+        krlog.Info("cleanup_on_exit is false, leaving tunnels and routes in place")
+        return
+    }
+
+    done := make(chan struct{})
+    go func() {
+        reconciler.Teardown()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        krlog.Info("Teardown complete")
+    case <-time.After(teardownTimeout):
+        krlog.Errorf("Teardown did not finish within %s, exiting anyway", teardownTimeout)
+    }
+}
+
+// runSignalManager handles the process lifecycle signals: SIGHUP forces a
+// reload, SIGUSR1 dumps debugging state, and SIGTERM/SIGINT run a bounded
+// teardown before canceling ctx so watchConfigFile can exit cleanly.
+func runSignalManager(ctx context.Context, cancel context.CancelFunc, configFilePath string) {
+    sigCh := make(chan os.Signal, 1)
+    signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGTERM, syscall.SIGINT)
+    defer signal.Stop(sigCh)
+
+    for {
+        select {
+        case sig := <-sigCh:
+            switch sig {
+            case syscall.SIGHUP:
+                krlog.Info("Received SIGHUP, forcing config reload")
+                reloadConfig(configFilePath, true)
+            case syscall.SIGUSR1:
+                dumpState(configFilePath)
+            case syscall.SIGTERM, syscall.SIGINT:
+                krlog.Infof("Received %s, shutting down", sig)
+                teardown()
+                cancel()
+                return
+            }
+        case <-ctx.Done():
+            return
+        }
     }
-    <-done
 }
 
 func main() {
@@ -250,25 +756,47 @@ func main() {
         log.Fatalf("Error loading initial config: %v", err)
     }
 
-    initLogger(config.ProgramSettings.LogFilePath, config.ProgramSettings.Logging.Info, config.ProgramSettings.Logging.Error, config.ProgramSettings.Logging.Debug)
+    initLogger(config.ProgramSettings)
 
+    startMetricsServer(config.ProgramSettings.Metrics)
+
+    backend, err := netlinkbackend.Select(config.ProgramSettings.Backend)
+    if err != nil {
+        krlog.Fatalf("Error selecting backend: %v", err)
+    }
+    reconciler = reconcile.New(backend)
+    healthMgr = healthcheck.NewManager(onHealthTransition)
+    healthMgr.Reload(healthCheckTargets())
+
+    // The health-check monitors started above can already call
+    // setupECMPRoutes through onHealthTransition -> reconcileECMP, and
+    // setupGRETunnels/setupStaticRoutes/setupECMPRoutes assume the caller
+    // holds reconcileMu, so it is held for the initial setup.
+    reconcileMu.Lock()
     if err := setupGRETunnels(); err != nil {
-        logger.Fatalf("Error setting up initial GRE tunnels: %v", err)
+        reconcileMu.Unlock()
+        krlog.Fatalf("Error setting up initial GRE tunnels: %v", err)
     }
 
     if err := setupStaticRoutes(); err != nil {
-        logger.Fatalf("Error setting up initial static routes: %v", err)
+        reconcileMu.Unlock()
+        krlog.Fatalf("Error setting up initial static routes: %v", err)
     }
 
     if err := setupECMPRoutes(); err != nil {
-        logger.Fatalf("Error setting up initial ECMP routes: %v", err)
+        reconcileMu.Unlock()
+        krlog.Fatalf("Error setting up initial ECMP routes: %v", err)
     }
+    reconcileMu.Unlock()
 
-    hash, err := getFileMD5(configFilePath)
+    hash, err := getConfigHash(configFilePath)
     if err != nil {
-        logger.Fatalf("Error computing initial file hash: %v", err)
+        krlog.Fatalf("Error computing initial config hash: %v", err)
     }
     currentHash = hash
 
-    watchConfigFile(configFilePath)
+    ctx, cancel := context.WithCancel(context.Background())
+    go runSignalManager(ctx, cancel, configFilePath)
+
+    watchConfigFile(ctx, configFilePath)
 }
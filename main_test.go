@@ -0,0 +1,190 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestMergeConfig_DuplicateTunnelRejected(t *testing.T) {
+    base := &Config{GRETunnels: []GRETunnel{{Name: "gre0", LocalIP: "10.0.0.1"}}}
+    overlay := Config{GRETunnels: []GRETunnel{{Name: "gre0", LocalIP: "10.0.0.2"}}}
+
+    err := mergeConfig(base, overlay, "conf.d/extra.yml")
+    if err == nil {
+        t.Fatal("expected an error for a duplicate gre tunnel name, got nil")
+    }
+    if len(base.GRETunnels) != 1 {
+        t.Fatalf("base.GRETunnels should be left unchanged on error, got %v", base.GRETunnels)
+    }
+}
+
+func TestMergeConfig_DuplicateRouteRejected(t *testing.T) {
+    base := &Config{StaticRoutes: []StaticRoute{{Destination: "10.1.0.0/24", Gateway: "10.0.0.1"}}}
+    overlay := Config{StaticRoutes: []StaticRoute{{Destination: "10.1.0.0/24", Gateway: "10.0.0.1"}}}
+
+    err := mergeConfig(base, overlay, "conf.d/extra.yml")
+    if err == nil {
+        t.Fatal("expected an error for a duplicate static route, got nil")
+    }
+}
+
+func TestMergeConfig_DifferentGatewaySameDestinationIsNotADuplicate(t *testing.T) {
+    base := &Config{StaticRoutes: []StaticRoute{{Destination: "10.1.0.0/24", Gateway: "10.0.0.1"}}}
+    overlay := Config{StaticRoutes: []StaticRoute{{Destination: "10.1.0.0/24", Gateway: "10.0.0.2"}}}
+
+    if err := mergeConfig(base, overlay, "conf.d/extra.yml"); err != nil {
+        t.Fatalf("same destination with a different gateway should be allowed, got error: %v", err)
+    }
+    if len(base.StaticRoutes) != 2 {
+        t.Fatalf("expected both routes to be kept, got %v", base.StaticRoutes)
+    }
+}
+
+func TestMergeConfig_AppendsInOrder(t *testing.T) {
+    base := &Config{
+        GRETunnels:   []GRETunnel{{Name: "gre0"}},
+        StaticRoutes: []StaticRoute{{Destination: "10.1.0.0/24", Gateway: "10.0.0.1"}},
+        ECMPRoutes:   []ECMPRoute{{Route: "10.2.0.0/24"}},
+    }
+    overlay := Config{
+        GRETunnels:   []GRETunnel{{Name: "gre1"}},
+        StaticRoutes: []StaticRoute{{Destination: "10.3.0.0/24", Gateway: "10.0.0.2"}},
+        ECMPRoutes:   []ECMPRoute{{Route: "10.4.0.0/24"}},
+    }
+
+    if err := mergeConfig(base, overlay, "conf.d/extra.yml"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if got := []string{base.GRETunnels[0].Name, base.GRETunnels[1].Name}; got[0] != "gre0" || got[1] != "gre1" {
+        t.Fatalf("expected base's tunnels before overlay's, got %v", got)
+    }
+    if got := []string{base.StaticRoutes[0].Destination, base.StaticRoutes[1].Destination}; got[0] != "10.1.0.0/24" || got[1] != "10.3.0.0/24" {
+        t.Fatalf("expected base's routes before overlay's, got %v", got)
+    }
+    if got := []string{base.ECMPRoutes[0].Route, base.ECMPRoutes[1].Route}; got[0] != "10.2.0.0/24" || got[1] != "10.4.0.0/24" {
+        t.Fatalf("expected base's ecmp routes before overlay's, got %v", got)
+    }
+}
+
+func TestConfDropIns_SortedAndYAMLOnly(t *testing.T) {
+    dir := t.TempDir()
+    for _, name := range []string{"b.yml", "a.yml", "notes.txt"} {
+        if err := os.WriteFile(filepath.Join(dir, name), []byte("gre_tunnels: []\n"), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    got, err := confDropIns(dir)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    want := []string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")}
+    if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+        t.Fatalf("confDropIns(%s) = %v, want %v", dir, got, want)
+    }
+}
+
+func TestConfDropIns_MissingDirReturnsEmpty(t *testing.T) {
+    got, err := confDropIns(filepath.Join(t.TempDir(), "does-not-exist"))
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if len(got) != 0 {
+        t.Fatalf("expected no drop-ins for a missing dir, got %v", got)
+    }
+}
+
+// writeConfigFixture lays out a main config file plus a conf.d directory of
+// drop-ins under dir, wiring ProgramSettings.ConfDir so loadConfig picks
+// them up, and returns the main config file's path.
+func writeConfigFixture(t *testing.T, dir string, mainYAML string, dropIns map[string]string) string {
+    t.Helper()
+    confDir := filepath.Join(dir, "conf.d")
+    if err := os.MkdirAll(confDir, 0o755); err != nil {
+        t.Fatal(err)
+    }
+    for name, contents := range dropIns {
+        if err := os.WriteFile(filepath.Join(confDir, name), []byte(contents), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    mainPath := filepath.Join(dir, "config.yml")
+    if err := os.WriteFile(mainPath, []byte(mainYAML), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    return mainPath
+}
+
+func TestLoadConfig_MergesDropIns(t *testing.T) {
+    dir := t.TempDir()
+    mainPath := writeConfigFixture(t, dir, `
+program_settings:
+  conf_d_dir: `+filepath.Join(dir, "conf.d")+`
+gre_tunnels:
+  - name: gre0
+    local_ip: 10.0.0.1
+    remote_ip: 10.0.0.2
+    tunnel_ip: 10.0.0.3
+    subnet_mask: 255.255.255.0
+`, map[string]string{
+        "a.yml": "static_routes:\n  - destination: 10.1.0.0/24\n    gateway: 10.0.0.1\n",
+    })
+
+    if err := loadConfig(mainPath); err != nil {
+        t.Fatalf("loadConfig failed: %v", err)
+    }
+    if len(config.GRETunnels) != 1 || config.GRETunnels[0].Name != "gre0" {
+        t.Fatalf("expected the main file's tunnel to survive, got %v", config.GRETunnels)
+    }
+    if len(config.StaticRoutes) != 1 || config.StaticRoutes[0].Destination != "10.1.0.0/24" {
+        t.Fatalf("expected the drop-in's route to be merged in, got %v", config.StaticRoutes)
+    }
+}
+
+func TestLoadConfig_DuplicateAcrossDropInsIsRejected(t *testing.T) {
+    dir := t.TempDir()
+    mainPath := writeConfigFixture(t, dir, `
+program_settings:
+  conf_d_dir: `+filepath.Join(dir, "conf.d")+`
+`, map[string]string{
+        "a.yml": "static_routes:\n  - destination: 10.1.0.0/24\n    gateway: 10.0.0.1\n",
+        "b.yml": "static_routes:\n  - destination: 10.1.0.0/24\n    gateway: 10.0.0.1\n",
+    })
+
+    if err := loadConfig(mainPath); err == nil {
+        t.Fatal("expected loadConfig to reject the same static route declared in two drop-ins")
+    }
+}
+
+func TestGetConfigHash_ChangesWhenDropInEdited(t *testing.T) {
+    dir := t.TempDir()
+    mainPath := writeConfigFixture(t, dir, `
+program_settings:
+  conf_d_dir: `+filepath.Join(dir, "conf.d")+`
+`, map[string]string{
+        "a.yml": "static_routes: []\n",
+    })
+
+    if err := loadConfig(mainPath); err != nil {
+        t.Fatalf("loadConfig failed: %v", err)
+    }
+    before, err := getConfigHash(mainPath)
+    if err != nil {
+        t.Fatalf("getConfigHash failed: %v", err)
+    }
+
+    dropInPath := filepath.Join(dir, "conf.d", "a.yml")
+    if err := os.WriteFile(dropInPath, []byte("static_routes:\n  - destination: 10.1.0.0/24\n    gateway: 10.0.0.1\n"), 0o644); err != nil {
+        t.Fatal(err)
+    }
+
+    after, err := getConfigHash(mainPath)
+    if err != nil {
+        t.Fatalf("getConfigHash failed: %v", err)
+    }
+    if before == after {
+        t.Fatal("expected editing a conf.d drop-in to change the config hash")
+    }
+}